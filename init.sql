@@ -44,7 +44,9 @@ CREATE TABLE chats (
   info varchar NOT NULL DEFAULT '',
   is_private boolean NOT NULL,
   link VARCHAR NOT NULL UNIQUE,
+  direct_key varchar UNIQUE, -- ids of the two users of a direct chat
   is_deleted bool DEFAULT false,
+  deleted_at timestamp,
   created_at timestamp DEFAULT NOW(),
   updated_at timestamp DEFAULT NOW(),
   FOREIGN KEY (owner_id) REFERENCES users (user_id)
@@ -57,7 +59,10 @@ CREATE TABLE chat_members (
   is_banned bool DEFAULT false,
   joined_at timestamp DEFAULT NOW(),
   banned_at timestamp DEFAULT NOW(),
+  banned_until timestamp, -- the ban is permanent when null
+  muted_until timestamp,
   is_deleted bool DEFAULT false,
+  last_read_message_id bigint NOT NULL DEFAULT 0, -- read position of the member
   PRIMARY KEY (chat_id, user_id),
   FOREIGN KEY (user_id) REFERENCES users (user_id),
   FOREIGN KEY (chat_id) REFERENCES chats (user_id)
@@ -69,25 +74,70 @@ CREATE TABLE messages (
   from_id bigint,
   to_id bigint,
   chat_id bigint,
+  reply_to_id bigint, -- message this one replies to
+  thread_id bigint, -- root of the thread, the replied message or its own root
   attachment_ids bigint[],
   is_deleted boolean DEFAULT false,
   is_read boolean DEFAULT false,
   created_at timestamp DEFAULT now(),
   updated_at timestamp DEFAULT now(),
   FOREIGN KEY (from_id) REFERENCES users (user_id),
-  FOREIGN KEY (chat_id) REFERENCES chats (chat_id)
+  FOREIGN KEY (chat_id) REFERENCES chats (chat_id),
+  FOREIGN KEY (reply_to_id) REFERENCES messages (message_id),
+  FOREIGN KEY (thread_id) REFERENCES messages (message_id)
 );
 
+CREATE INDEX messages_thread_idx ON messages (thread_id, message_id) WHERE thread_id IS NOT NULL;
+
 CREATE TABLE mentions (
   mention_id bigint primary key generated always as identity,
   user_id INT,
   mentioned_user_id INT,
   message_id INT,
+  is_seen boolean NOT NULL DEFAULT false,
   created_at timestamp DEFAULT now(),
   updated_at timestamp DEFAULT now(),
   FOREIGN KEY (message_id) REFERENCES messages(message_id)
 );
 
+CREATE TABLE reactions (
+  message_id bigint,
+  user_id bigint,
+  emoji varchar(32) NOT NULL,
+  created_at timestamp DEFAULT now(),
+  PRIMARY KEY (message_id, user_id, emoji),
+  FOREIGN KEY (message_id) REFERENCES messages(message_id),
+  FOREIGN KEY (user_id) REFERENCES users (user_id)
+);
+
+CREATE TABLE chat_invites (
+  invite_id bigint primary key generated always as identity,
+  chat_id bigint NOT NULL,
+  creator_id bigint NOT NULL,
+  token varchar NOT NULL UNIQUE,
+  expires_at timestamp, -- never expires when null
+  max_uses int NOT NULL DEFAULT 0, -- unlimited when 0
+  uses int NOT NULL DEFAULT 0,
+  requires_approval bool NOT NULL DEFAULT false,
+  is_revoked bool NOT NULL DEFAULT false,
+  created_at timestamp DEFAULT now(),
+  FOREIGN KEY (chat_id) REFERENCES chats (chat_id),
+  FOREIGN KEY (creator_id) REFERENCES users (user_id)
+);
+
+CREATE TABLE chat_join_requests (
+  chat_id bigint,
+  user_id bigint,
+  invite_id bigint NOT NULL,
+  created_at timestamp DEFAULT now(),
+  PRIMARY KEY (chat_id, user_id),
+  FOREIGN KEY (chat_id) REFERENCES chats (chat_id),
+  FOREIGN KEY (user_id) REFERENCES users (user_id),
+  FOREIGN KEY (invite_id) REFERENCES chat_invites (invite_id)
+);
+
+CREATE INDEX mentions_unseen_idx ON mentions (mentioned_user_id) WHERE is_seen = false;
+
 -- CREATE TABLE IF NOT EXISTS auth.session
 -- (
 --   id        INT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
@@ -15,6 +15,7 @@ var (
 var (
 	ErrInternal      = errors.New("internal error")
 	ErrNotAuthorized = errors.New("not authorized")
+	ErrForbidden     = errors.New("action is forbidden")
 )
 
 var (
@@ -22,4 +23,29 @@ var (
 	ErrChatsNotFound      = errors.New("chats not found")
 	ErrChatNotUpdated     = errors.New("user not updated")
 	ErrChatMemberNotFound = errors.New("chat member not found")
+	ErrChatMemberBanned   = errors.New("chat member is banned")
+	ErrChatDeleted        = errors.New("chat is deleted")
+	ErrChatInvalid        = errors.New("invalid chat")
+	ErrChatMemberMuted    = errors.New("chat member is muted")
+	ErrChatRestoreExpired = errors.New("chat can no longer be restored")
+	ErrModerationInvalid  = errors.New("invalid moderation action")
+)
+
+var (
+	ErrInviteNotFound      = errors.New("invite not found")
+	ErrInviteExpired       = errors.New("invite is expired or revoked")
+	ErrInviteInvalid       = errors.New("invalid invite")
+	ErrJoinRequestNotFound = errors.New("join request not found")
+)
+
+var (
+	ErrMessageNotSaved = errors.New("message not saved")
+	ErrMessageNotFound = errors.New("message not found")
+	ErrMessageInvalid  = errors.New("invalid message")
+	ErrReactionInvalid = errors.New("invalid reaction")
+)
+
+var (
+	ErrPresenceInvalid = errors.New("invalid presence status")
+	ErrSessionNotFound = errors.New("session not found")
 )
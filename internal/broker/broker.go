@@ -0,0 +1,114 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	TypeMemory = "memory"
+	TypeRedis  = "redis"
+)
+
+// Broker fans out payloads published to a topic to every subscriber of that
+// topic, possibly across several nodes.
+type Broker interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	Subscribe(ctx context.Context, topic string) (Subscription, error)
+	Close() error
+}
+
+// Subscription delivers the payloads of a single topic until it is
+// unsubscribed.
+type Subscription interface {
+	Channel() <-chan []byte
+	Unsubscribe() error
+}
+
+// NewBroker creates the broker of the given type. The in-memory broker only
+// connects subscribers of the same process, the redis one is used to run
+// several nodes.
+func NewBroker(brokerType string, redisURL string) (Broker, error) {
+	switch brokerType {
+	case TypeMemory, "":
+		return NewMemoryBroker(), nil
+	case TypeRedis:
+		return NewRedisBroker(redisURL)
+	default:
+		return nil, fmt.Errorf("unknown broker type %q", brokerType)
+	}
+}
+
+const subscriptionBufferSize = 256
+
+// Every how many dropped payloads a subscription logs them
+const dropLogInterval = 1000
+
+// subscription is the Subscription shared by the brokers. Payloads are
+// handed over by deliver until the subscription is closed.
+type subscription struct {
+	topic         string
+	ch            chan []byte
+	done          chan struct{}
+	once          sync.Once
+	mu            sync.RWMutex
+	closed        bool
+	dropped       atomic.Uint64
+	onUnsubscribe func(*subscription)
+}
+
+func newSubscription(topic string, onUnsubscribe func(*subscription)) *subscription {
+	return &subscription{
+		topic:         topic,
+		ch:            make(chan []byte, subscriptionBufferSize),
+		done:          make(chan struct{}),
+		onUnsubscribe: onUnsubscribe,
+	}
+}
+
+func (s *subscription) Channel() <-chan []byte {
+	return s.ch
+}
+
+func (s *subscription) Unsubscribe() error {
+	s.onUnsubscribe(s)
+	s.close()
+
+	return nil
+}
+
+// deliver hands the payload over without waiting. The payload is dropped if
+// the buffer of the subscription is full, so a subscriber that falls behind
+// never holds up the delivery to the others.
+func (s *subscription) deliver(payload []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.ch <- payload:
+	default:
+		if dropped := s.dropped.Add(1); dropped == 1 || dropped%dropLogInterval == 0 {
+			log.Printf("subscription to %s is full, %v payloads dropped", s.topic, dropped)
+		}
+	}
+}
+
+// close stops the delivery and closes the channel once no publisher is
+// writing to it anymore.
+func (s *subscription) close() {
+	s.once.Do(func() {
+		close(s.done)
+
+		s.mu.Lock()
+		s.closed = true
+		close(s.ch)
+		s.mu.Unlock()
+	})
+}
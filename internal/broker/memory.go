@@ -0,0 +1,73 @@
+package broker
+
+import (
+	"context"
+	"sync"
+)
+
+type memoryBroker struct {
+	mu     sync.RWMutex
+	topics map[string]map[*subscription]bool
+}
+
+func NewMemoryBroker() *memoryBroker {
+	return &memoryBroker{
+		topics: make(map[string]map[*subscription]bool),
+	}
+}
+
+func (b *memoryBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	b.mu.RLock()
+	subscriptions := make([]*subscription, 0, len(b.topics[topic]))
+	for subscription := range b.topics[topic] {
+		subscriptions = append(subscriptions, subscription)
+	}
+	b.mu.RUnlock()
+
+	for _, subscription := range subscriptions {
+		subscription.deliver(payload)
+	}
+
+	return nil
+}
+
+func (b *memoryBroker) Subscribe(ctx context.Context, topic string) (Subscription, error) {
+	sub := newSubscription(topic, b.unsubscribe)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.topics[topic]; !ok {
+		b.topics[topic] = make(map[*subscription]bool)
+	}
+	b.topics[topic][sub] = true
+
+	return sub, nil
+}
+
+func (b *memoryBroker) Close() error {
+	b.mu.Lock()
+	topics := b.topics
+	b.topics = make(map[string]map[*subscription]bool)
+	b.mu.Unlock()
+
+	for _, subscriptions := range topics {
+		for subscription := range subscriptions {
+			subscription.close()
+		}
+	}
+
+	return nil
+}
+
+func (b *memoryBroker) unsubscribe(subscription *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if subscriptions, ok := b.topics[subscription.topic]; ok {
+		delete(subscriptions, subscription)
+		if len(subscriptions) == 0 {
+			delete(b.topics, subscription.topic)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package broker
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisBroker shares a single pub/sub connection between all subscriptions
+// of the node and dispatches the payloads by channel.
+type redisBroker struct {
+	client *redis.Client
+	pubsub *redis.PubSub
+	mu     sync.RWMutex
+	topics map[string]map[*subscription]bool
+}
+
+func NewRedisBroker(redisURL string) (*redisBroker, error) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(opt)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, err
+	}
+
+	b := &redisBroker{
+		client: client,
+		pubsub: client.Subscribe(context.Background()),
+		topics: make(map[string]map[*subscription]bool),
+	}
+	go b.dispatch()
+
+	return b, nil
+}
+
+// Client exposes the underlying connection for components that keep their
+// own state in redis.
+func (b *redisBroker) Client() *redis.Client {
+	return b.client
+}
+
+func (b *redisBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	return b.client.Publish(ctx, topic, payload).Err()
+}
+
+func (b *redisBroker) Subscribe(ctx context.Context, topic string) (Subscription, error) {
+	sub := newSubscription(topic, b.unsubscribe)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.topics[topic]; !ok {
+		if err := b.pubsub.Subscribe(ctx, topic); err != nil {
+			return nil, err
+		}
+		b.topics[topic] = make(map[*subscription]bool)
+	}
+	b.topics[topic][sub] = true
+
+	return sub, nil
+}
+
+func (b *redisBroker) Close() error {
+	b.mu.Lock()
+	topics := b.topics
+	b.topics = make(map[string]map[*subscription]bool)
+	b.mu.Unlock()
+
+	for _, subscriptions := range topics {
+		for subscription := range subscriptions {
+			subscription.close()
+		}
+	}
+
+	if err := b.pubsub.Close(); err != nil {
+		return err
+	}
+
+	return b.client.Close()
+}
+
+func (b *redisBroker) dispatch() {
+	for msg := range b.pubsub.Channel() {
+		b.mu.RLock()
+		subscriptions := make([]*subscription, 0, len(b.topics[msg.Channel]))
+		for subscription := range b.topics[msg.Channel] {
+			subscriptions = append(subscriptions, subscription)
+		}
+		b.mu.RUnlock()
+
+		payload := []byte(msg.Payload)
+		for _, subscription := range subscriptions {
+			subscription.deliver(payload)
+		}
+	}
+}
+
+func (b *redisBroker) unsubscribe(subscription *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subscriptions, ok := b.topics[subscription.topic]
+	if !ok {
+		return
+	}
+
+	delete(subscriptions, subscription)
+	if len(subscriptions) == 0 {
+		delete(b.topics, subscription.topic)
+		if err := b.pubsub.Unsubscribe(context.Background(), subscription.topic); err != nil {
+			log.Printf("can't unsubscribe from %s: %s", subscription.topic, err)
+		}
+	}
+}
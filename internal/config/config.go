@@ -32,6 +32,34 @@ type Config struct {
 		WriteTimeout       time.Duration `yaml:"writeTimeout"`
 		MaxHeaderMegabytes int           `yaml:"maxHeaderBytes"`
 	} `yaml:"http"`
+	Broker struct {
+		Type     string `yaml:"type"` // memory, redis
+		RedisURL string `yaml:"redisURL"`
+		Codec    string `yaml:"codec" env-default:"json"` // json, msgpack
+	} `yaml:"broker"`
+	Websocket struct {
+		SendQueueSize         int           `yaml:"sendQueueSize" env-default:"256"`
+		SlowConsumerPolicy    string        `yaml:"slowConsumerPolicy" env-default:"drop-oldest"` // drop-oldest, drop-newest, disconnect
+		SlowConsumerCloseCode int           `yaml:"slowConsumerCloseCode" env-default:"1013"`     // 1008, 1013
+		RoomIdleTimeout       time.Duration `yaml:"roomIdleTimeout" env-default:"5m"`             // 0 keeps empty rooms
+		StatsInterval         time.Duration `yaml:"statsInterval" env-default:"1m"`               // 0 doesn't log queue metrics
+	} `yaml:"websocket"`
+	RateLimit struct {
+		ConnectionRate  float64       `yaml:"connectionRate" env-default:"20"` // frames per second, 0 disables the limit
+		ConnectionBurst int           `yaml:"connectionBurst" env-default:"40"`
+		UserRate        float64       `yaml:"userRate" env-default:"30"`
+		UserBurst       int           `yaml:"userBurst" env-default:"60"`
+		ChatRate        float64       `yaml:"chatRate" env-default:"50"`
+		ChatBurst       int           `yaml:"chatBurst" env-default:"100"`
+		MaxViolations   int           `yaml:"maxViolations" env-default:"20"` // limited frames before disconnecting, 0 never disconnects
+		ViolationWindow time.Duration `yaml:"violationWindow" env-default:"1m"`
+	} `yaml:"rateLimit"`
+	Chats struct {
+		RestoreWindow time.Duration `yaml:"restoreWindow" env-default:"720h"` // how long deleted chats can be restored
+	} `yaml:"chats"`
+	Presence struct {
+		TTL time.Duration `yaml:"ttl" env-default:"30s"`
+	} `yaml:"presence"`
 }
 
 func LoadConfigs(yamlFile, envFile string) (Config, error) {
@@ -56,6 +84,9 @@ func LoadConfigs(yamlFile, envFile string) (Config, error) {
 	cfg.Auth.SigningKey = os.Getenv("JWT_KEY")
 	cfg.HTTP.Host = os.Getenv("HTTP_HOST")
 	cfg.HTTP.Port = os.Getenv("HTTP_PORT")
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		cfg.Broker.RedisURL = redisURL
+	}
 
 	return cfg, nil
 }
@@ -0,0 +1,530 @@
+package handlers
+
+import (
+	"chatie/internal/models"
+	"chatie/internal/ws"
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ChatService interface {
+	GetAllUserChats(ctx context.Context, userID int) ([]models.Chat, error)
+	OpenDirectChat(ctx context.Context, userID int, peerID int) (*models.Chat, error)
+	AddChat(ctx context.Context, chat models.Chat, userID int) (*models.Chat, error)
+	GetChat(ctx context.Context, chatID int, userID int) (*models.Chat, error)
+	UpdateChat(ctx context.Context, chatID int, userID int, update models.ChatUpdate) (*models.Chat, error)
+	Moderate(ctx context.Context, moderation models.Moderation) (*models.Moderation, error)
+	TransferOwnership(ctx context.Context, chatID int, ownerID int, newOwnerID int) (*models.Chat, error)
+	DeleteChat(ctx context.Context, chatID int, userID int) (*models.Chat, error)
+	RestoreChat(ctx context.Context, chatID int, userID int) (*models.Chat, error)
+}
+
+type MessageService interface {
+	GetHistory(ctx context.Context, chatID int, userID int, before int, limit int) ([]models.Message, error)
+	GetThread(ctx context.Context, chatID int, userID int, rootID int, after int, limit int) (*models.Thread, error)
+	MarkRead(ctx context.Context, chatID int, userID int, messageID int) (*models.ReadReceipt, error)
+	EditMessage(ctx context.Context, chatID int, userID int, messageID int, text string) (*models.Message, error)
+	DeleteMessage(ctx context.Context, chatID int, userID int, messageID int) (*models.Message, error)
+	AddReaction(ctx context.Context, chatID int, userID int, messageID int, emoji string) (*models.ReactionsUpdate, error)
+	RemoveReaction(ctx context.Context, chatID int, userID int, messageID int, emoji string) (*models.ReactionsUpdate, error)
+}
+
+// ChatNotifier pushes events to the live members of a chat.
+type ChatNotifier interface {
+	NotifyChat(chatID int, action string, data any)
+	InviteToChat(chatID int, userID int)
+	ApplyModeration(moderation *models.Moderation)
+	CloseChat(chat *models.Chat)
+	ReopenChat(chat *models.Chat)
+}
+
+type chatHandler struct {
+	chatService    ChatService
+	messageService MessageService
+	notifier       ChatNotifier
+}
+
+func NewChatHandler(
+	chatService ChatService,
+	messageService MessageService,
+	notifier ChatNotifier,
+) *chatHandler {
+	return &chatHandler{
+		chatService:    chatService,
+		messageService: messageService,
+		notifier:       notifier,
+	}
+}
+
+func (h *chatHandler) GetChats(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chats, err := h.chatService.GetAllUserChats(context.Background(), userID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, chats)
+}
+
+type createChatRequest struct {
+	Name    string `json:"name"`
+	Info    string `json:"info"`
+	Private bool   `json:"private"`
+}
+
+// CreateChat creates a group chat owned by the user, whose connections join
+// it right away.
+func (h *chatHandler) CreateChat(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	var request createChatRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	chat, err := h.chatService.AddChat(context.Background(), models.Chat{
+		Name:    request.Name,
+		Info:    request.Info,
+		Private: request.Private,
+	}, userID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	h.notifier.InviteToChat(chat.ID, userID)
+
+	c.JSON(http.StatusCreated, chat)
+}
+
+func (h *chatHandler) GetChat(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return
+	}
+
+	chat, err := h.chatService.GetChat(context.Background(), chatID, userID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, chat)
+}
+
+func (h *chatHandler) UpdateChat(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return
+	}
+
+	var update models.ChatUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	chat, err := h.chatService.UpdateChat(context.Background(), chatID, userID, update)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	h.notifier.NotifyChat(chatID, ws.ChatUpdatedAction, chat)
+
+	c.JSON(http.StatusOK, chat)
+}
+
+type transferOwnershipRequest struct {
+	UserID int `json:"userID" binding:"required"`
+}
+
+// TransferOwnership makes the member in the body the owner of the chat.
+func (h *chatHandler) TransferOwnership(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return
+	}
+
+	var request transferOwnershipRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	chat, err := h.chatService.TransferOwnership(context.Background(), chatID, userID, request.UserID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	h.notifier.NotifyChat(chatID, ws.OwnerChangedAction, chat)
+
+	c.JSON(http.StatusOK, chat)
+}
+
+// DeleteChat soft deletes the chat and closes it for its live members.
+func (h *chatHandler) DeleteChat(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return
+	}
+
+	chat, err := h.chatService.DeleteChat(context.Background(), chatID, userID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	h.notifier.CloseChat(chat)
+
+	c.JSON(http.StatusOK, chat)
+}
+
+// RestoreChat brings a deleted chat back and has its members join it again.
+func (h *chatHandler) RestoreChat(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return
+	}
+
+	chat, err := h.chatService.RestoreChat(context.Background(), chatID, userID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	h.notifier.ReopenChat(chat)
+
+	c.JSON(http.StatusOK, chat)
+}
+
+// OpenDirectChat returns the direct chat with the user in the path, creating
+// it on first use, and has the connections of both users join it.
+func (h *chatHandler) OpenDirectChat(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	peerID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	chat, err := h.chatService.OpenDirectChat(context.Background(), userID, peerID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	h.notifier.InviteToChat(chat.ID, userID)
+	h.notifier.InviteToChat(chat.ID, peerID)
+
+	c.JSON(http.StatusOK, chat)
+}
+
+func (h *chatHandler) GetMessages(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return
+	}
+
+	before, err := strconv.Atoi(c.DefaultQuery("before", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid before"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+
+	messages, err := h.messageService.GetHistory(context.Background(), chatID, userID, before, limit)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, messages)
+}
+
+func (h *chatHandler) GetThread(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, messageID, ok := parseMessagePath(c)
+	if !ok {
+		return
+	}
+
+	after, err := strconv.Atoi(c.DefaultQuery("after", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid after"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+
+	thread, err := h.messageService.GetThread(context.Background(), chatID, userID, messageID, after, limit)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, thread)
+}
+
+type markReadRequest struct {
+	MessageID int `json:"messageID"`
+}
+
+func (h *chatHandler) MarkRead(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return
+	}
+
+	var request markReadRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	receipt, err := h.messageService.MarkRead(context.Background(), chatID, userID, request.MessageID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	h.notifier.NotifyChat(chatID, ws.ReadUpdatedAction, receipt)
+
+	c.JSON(http.StatusOK, receipt)
+}
+
+type editMessageRequest struct {
+	Text string `json:"text"`
+}
+
+func (h *chatHandler) EditMessage(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, messageID, ok := parseMessagePath(c)
+	if !ok {
+		return
+	}
+
+	var request editMessageRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := h.messageService.EditMessage(context.Background(), chatID, userID, messageID, request.Text)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	h.notifier.NotifyChat(chatID, ws.MessageEditedAction, message)
+
+	c.JSON(http.StatusOK, message)
+}
+
+func (h *chatHandler) DeleteMessage(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, messageID, ok := parseMessagePath(c)
+	if !ok {
+		return
+	}
+
+	message, err := h.messageService.DeleteMessage(context.Background(), chatID, userID, messageID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	h.notifier.NotifyChat(chatID, ws.MessageDeletedAction, message)
+
+	c.JSON(http.StatusOK, message)
+}
+
+func (h *chatHandler) AddReaction(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, messageID, ok := parseMessagePath(c)
+	if !ok {
+		return
+	}
+
+	update, err := h.messageService.AddReaction(context.Background(), chatID, userID, messageID, c.Param("emoji"))
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	h.notifier.NotifyChat(chatID, ws.ReactionsUpdatedAction, update)
+
+	c.JSON(http.StatusOK, update)
+}
+
+func (h *chatHandler) RemoveReaction(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, messageID, ok := parseMessagePath(c)
+	if !ok {
+		return
+	}
+
+	update, err := h.messageService.RemoveReaction(context.Background(), chatID, userID, messageID, c.Param("emoji"))
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	h.notifier.NotifyChat(chatID, ws.ReactionsUpdatedAction, update)
+
+	c.JSON(http.StatusOK, update)
+}
+
+// parseMessagePath reads the chat and message ids of
+// /chats/:id/messages/:messageID and answers with 400 if they are invalid.
+func parseMessagePath(c *gin.Context) (int, int, bool) {
+	chatID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return 0, 0, false
+	}
+
+	messageID, err := strconv.Atoi(c.Param("messageID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid message id"})
+		return 0, 0, false
+	}
+
+	return chatID, messageID, true
+}
+
+type moderationRequest struct {
+	Duration int `json:"duration"` // seconds, a ban without one is permanent
+}
+
+type setRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+func (h *chatHandler) KickMember(c *gin.Context) {
+	h.moderate(c, models.ModerationKick, 0)
+}
+
+func (h *chatHandler) BanMember(c *gin.Context) {
+	var request moderationRequest
+	if err := c.ShouldBindJSON(&request); err != nil && c.Request.ContentLength != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.moderate(c, models.ModerationBan, request.Duration)
+}
+
+func (h *chatHandler) UnbanMember(c *gin.Context) {
+	h.moderate(c, models.ModerationUnban, 0)
+}
+
+func (h *chatHandler) MuteMember(c *gin.Context) {
+	var request moderationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.moderate(c, models.ModerationMute, request.Duration)
+}
+
+func (h *chatHandler) UnmuteMember(c *gin.Context) {
+	h.moderate(c, models.ModerationUnmute, 0)
+}
+
+// SetMemberRole promotes the member to admin or demotes them to a regular
+// member.
+func (h *chatHandler) SetMemberRole(c *gin.Context) {
+	var request setRoleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch request.Role {
+	case models.UserAdmin:
+		h.moderate(c, models.ModerationPromote, 0)
+	case models.UserDefault:
+		h.moderate(c, models.ModerationDemote, 0)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role"})
+	}
+}
+
+// moderate takes the action on the member in the path of
+// /chats/:id/members/:userID and pushes it to the live members.
+func (h *chatHandler) moderate(c *gin.Context, action string, duration int) {
+	actorID := c.GetInt(UserKeyCtx)
+
+	chatID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	moderation, err := h.chatService.Moderate(context.Background(), models.Moderation{
+		Action:   action,
+		ChatID:   chatID,
+		UserID:   userID,
+		ActorID:  actorID,
+		Duration: duration,
+	})
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	h.notifier.ApplyModeration(moderation)
+
+	c.JSON(http.StatusOK, moderation)
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"chatie/internal/models"
+	"chatie/internal/ws"
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type InviteService interface {
+	CreateInvite(ctx context.Context, chatID int, userID int, invite models.Invite) (*models.Invite, error)
+	GetInvites(ctx context.Context, chatID int, userID int) ([]models.Invite, error)
+	RevokeInvite(ctx context.Context, chatID int, userID int, inviteID int) (*models.Invite, error)
+	JoinByToken(ctx context.Context, token string, userID int) (*models.JoinResult, error)
+	GetJoinRequests(ctx context.Context, chatID int, userID int) ([]models.JoinRequest, error)
+	ApproveJoinRequest(ctx context.Context, chatID int, adminID int, userID int) (*models.ChatUser, error)
+	RejectJoinRequest(ctx context.Context, chatID int, adminID int, userID int) error
+}
+
+type inviteHandler struct {
+	inviteService InviteService
+	notifier      ChatNotifier
+}
+
+func NewInviteHandler(inviteService InviteService, notifier ChatNotifier) *inviteHandler {
+	return &inviteHandler{
+		inviteService: inviteService,
+		notifier:      notifier,
+	}
+}
+
+type createInviteRequest struct {
+	ExpiresAt        *time.Time `json:"expiresAt"`
+	MaxUses          int        `json:"maxUses"`
+	RequiresApproval bool       `json:"requiresApproval"`
+}
+
+func (h *inviteHandler) CreateInvite(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return
+	}
+
+	var request createInviteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invite, err := h.inviteService.CreateInvite(context.Background(), chatID, userID, models.Invite{
+		ExpiresAt:        request.ExpiresAt,
+		MaxUses:          request.MaxUses,
+		RequiresApproval: request.RequiresApproval,
+	})
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, invite)
+}
+
+func (h *inviteHandler) GetInvites(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return
+	}
+
+	invites, err := h.inviteService.GetInvites(context.Background(), chatID, userID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invites)
+}
+
+func (h *inviteHandler) RevokeInvite(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return
+	}
+
+	inviteID, err := strconv.Atoi(c.Param("inviteID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invite id"})
+		return
+	}
+
+	invite, err := h.inviteService.RevokeInvite(context.Background(), chatID, userID, inviteID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invite)
+}
+
+// Join uses the invite in the path. New members join the chat on their
+// connections and the other members are told about them.
+func (h *inviteHandler) Join(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	result, err := h.inviteService.JoinByToken(context.Background(), c.Param("token"), userID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	if result.Pending {
+		c.JSON(http.StatusAccepted, result)
+		return
+	}
+
+	if !result.AlreadyMember {
+		h.notifyMemberAdded(result.Chat.ID, result.Member)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *inviteHandler) GetJoinRequests(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	chatID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return
+	}
+
+	requests, err := h.inviteService.GetJoinRequests(context.Background(), chatID, userID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, requests)
+}
+
+func (h *inviteHandler) ApproveJoinRequest(c *gin.Context) {
+	adminID := c.GetInt(UserKeyCtx)
+
+	chatID, userID, ok := parseJoinRequestPath(c)
+	if !ok {
+		return
+	}
+
+	member, err := h.inviteService.ApproveJoinRequest(context.Background(), chatID, adminID, userID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	h.notifyMemberAdded(chatID, member)
+
+	c.JSON(http.StatusOK, member)
+}
+
+func (h *inviteHandler) RejectJoinRequest(c *gin.Context) {
+	adminID := c.GetInt(UserKeyCtx)
+
+	chatID, userID, ok := parseJoinRequestPath(c)
+	if !ok {
+		return
+	}
+
+	if err := h.inviteService.RejectJoinRequest(context.Background(), chatID, adminID, userID); err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *inviteHandler) notifyMemberAdded(chatID int, member *models.ChatUser) {
+	h.notifier.NotifyChat(chatID, ws.MemberAddedAction, member.Profile())
+	h.notifier.InviteToChat(chatID, member.ID)
+}
+
+// parseJoinRequestPath reads the chat and user ids of
+// /chats/:id/join-requests/:userID and answers with 400 if they are invalid.
+func parseJoinRequestPath(c *gin.Context) (int, int, bool) {
+	chatID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return 0, 0, false
+	}
+
+	userID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return 0, 0, false
+	}
+
+	return chatID, userID, true
+}
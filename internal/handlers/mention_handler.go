@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"chatie/internal/models"
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MentionService interface {
+	GetUnseenMentions(ctx context.Context, userID int) ([]models.Mention, error)
+	MarkMentionsSeen(ctx context.Context, userID int, mentionIDs []int) (int, error)
+}
+
+type mentionHandler struct {
+	mentionService MentionService
+}
+
+func NewMentionHandler(mentionService MentionService) *mentionHandler {
+	return &mentionHandler{
+		mentionService: mentionService,
+	}
+}
+
+func (h *mentionHandler) GetUnseenMentions(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	mentions, err := h.mentionService.GetUnseenMentions(context.Background(), userID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, mentions)
+}
+
+type markMentionsSeenRequest struct {
+	IDs []int `json:"ids" binding:"required"`
+}
+
+func (h *mentionHandler) MarkMentionsSeen(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	var request markMentionsSeenRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := h.mentionService.MarkMentionsSeen(context.Background(), userID, request.IDs)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": updated})
+}
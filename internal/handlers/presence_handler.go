@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"chatie/internal/models"
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PresenceService interface {
+	GetOnlineUsers(ctx context.Context, userID int) ([]models.User, error)
+}
+
+type presenceHandler struct {
+	presenceService PresenceService
+}
+
+func NewPresenceHandler(presenceService PresenceService) *presenceHandler {
+	return &presenceHandler{
+		presenceService: presenceService,
+	}
+}
+
+func (h *presenceHandler) GetOnlineUsers(c *gin.Context) {
+	userID := c.GetInt(UserKeyCtx)
+
+	users, err := h.presenceService.GetOnlineUsers(context.Background(), userID)
+	if err != nil {
+		getErrorResponse(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
@@ -7,7 +7,14 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func Routes(userHandler *userHandler, hub *ws.WsServer) *gin.Engine {
+func Routes(
+	userHandler *userHandler,
+	chatHandler *chatHandler,
+	presenceHandler *presenceHandler,
+	mentionHandler *mentionHandler,
+	inviteHandler *inviteHandler,
+	hub *ws.WsServer,
+) *gin.Engine {
 	r := gin.Default()
 
 	ag := r.Group("api/")
@@ -24,6 +31,56 @@ func Routes(userHandler *userHandler, hub *ws.WsServer) *gin.Engine {
 	ag.GET("/user/ws", func(c *gin.Context) {
 		ws.ServeWS(hub, c)
 	})
+	ag.GET("/user/sse", func(c *gin.Context) {
+		ws.ServeSSE(hub, c)
+	})
+	ag.POST("/user/poll", func(c *gin.Context) {
+		ws.ServeLongPoll(hub, c)
+	})
+	ag.GET("/user/sessions/:id/poll", func(c *gin.Context) {
+		ws.ServePoll(hub, c, c.Param("id"))
+	})
+	ag.POST("/user/sessions/:id/messages", func(c *gin.Context) {
+		ws.ServeSend(hub, c, c.Param("id"))
+	})
+	ag.DELETE("/user/sessions/:id", func(c *gin.Context) {
+		ws.CloseSession(hub, c, c.Param("id"))
+	})
+
+	ag.GET("/users/online", presenceHandler.GetOnlineUsers)
+
+	ag.GET("/mentions", mentionHandler.GetUnseenMentions)
+	ag.POST("/mentions/seen", mentionHandler.MarkMentionsSeen)
+
+	ag.GET("/chats", chatHandler.GetChats)
+	ag.POST("/chats", chatHandler.CreateChat)
+	ag.GET("/chats/:id", chatHandler.GetChat)
+	ag.PATCH("/chats/:id", chatHandler.UpdateChat)
+	ag.DELETE("/chats/:id", chatHandler.DeleteChat)
+	ag.POST("/chats/:id/restore", chatHandler.RestoreChat)
+	ag.POST("/chats/:id/owner", chatHandler.TransferOwnership)
+	ag.POST("/chats/direct/:userID", chatHandler.OpenDirectChat)
+	ag.GET("/chats/:id/messages", chatHandler.GetMessages)
+	ag.GET("/chats/:id/messages/:messageID/thread", chatHandler.GetThread)
+	ag.PATCH("/chats/:id/messages/:messageID", chatHandler.EditMessage)
+	ag.DELETE("/chats/:id/messages/:messageID", chatHandler.DeleteMessage)
+	ag.PUT("/chats/:id/messages/:messageID/reactions/:emoji", chatHandler.AddReaction)
+	ag.DELETE("/chats/:id/messages/:messageID/reactions/:emoji", chatHandler.RemoveReaction)
+	ag.POST("/chats/:id/read", chatHandler.MarkRead)
+	ag.DELETE("/chats/:id/members/:userID", chatHandler.KickMember)
+	ag.PUT("/chats/:id/members/:userID/ban", chatHandler.BanMember)
+	ag.DELETE("/chats/:id/members/:userID/ban", chatHandler.UnbanMember)
+	ag.PUT("/chats/:id/members/:userID/mute", chatHandler.MuteMember)
+	ag.DELETE("/chats/:id/members/:userID/mute", chatHandler.UnmuteMember)
+	ag.PUT("/chats/:id/members/:userID/role", chatHandler.SetMemberRole)
+
+	ag.POST("/chats/:id/invites", inviteHandler.CreateInvite)
+	ag.GET("/chats/:id/invites", inviteHandler.GetInvites)
+	ag.DELETE("/chats/:id/invites/:inviteID", inviteHandler.RevokeInvite)
+	ag.GET("/chats/:id/join-requests", inviteHandler.GetJoinRequests)
+	ag.POST("/chats/:id/join-requests/:userID", inviteHandler.ApproveJoinRequest)
+	ag.DELETE("/chats/:id/join-requests/:userID", inviteHandler.RejectJoinRequest)
+	ag.POST("/join/:token", inviteHandler.Join)
 
 	return r
 }
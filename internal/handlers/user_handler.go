@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"chatie/internal/apperror"
 	"chatie/internal/config"
 	"chatie/internal/models"
 	manager "chatie/pkg/auth"
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -191,7 +193,23 @@ func (u *userHandler) RefreshAuth(c *gin.Context) {
 }
 
 func getErrorResponse(c *gin.Context, err error) {
+	if errors.Is(err, apperror.ErrMessageInvalid) || errors.Is(err, apperror.ErrReactionInvalid) ||
+		errors.Is(err, apperror.ErrChatInvalid) || errors.Is(err, apperror.ErrInviteInvalid) ||
+		errors.Is(err, apperror.ErrModerationInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	switch err {
+	case apperror.ErrChatMemberNotFound, apperror.ErrChatMemberBanned, apperror.ErrChatMemberMuted:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case apperror.ErrMessageNotFound, apperror.ErrChatNotFound, apperror.ErrUserNotFound,
+		apperror.ErrInviteNotFound, apperror.ErrJoinRequestNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case apperror.ErrForbidden:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case apperror.ErrChatDeleted, apperror.ErrInviteExpired, apperror.ErrChatRestoreExpired:
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
 	// case apperror.ErrInternal:
 	// 	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	// case apperror.ErrUserExists:
@@ -200,8 +218,6 @@ func getErrorResponse(c *gin.Context, err error) {
 	// 	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 	// case apperror.ErrUsersNotFound:
 	// 	c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-	// case apperror.ErrUserNotFound:
-	// 	c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 	default:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	}
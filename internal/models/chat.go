@@ -1,6 +1,18 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxChatNameLength = 64
+	maxChatInfoLength = 512
+)
 
 // type WsChat struct {
 // 	BaseModel
@@ -13,19 +25,81 @@ import "github.com/google/uuid"
 
 type Chat struct {
 	BaseModel
-	Name     string `json:"name"`
-	Private  bool   `json:"private"`
-	Info     string `json:"info"`
-	Link     string
-	OwnerID  int
-	Members  []User    `json:"members"`
-	Messages []Message `json:"messages"`
+	Name      string `json:"name"`
+	Private   bool   `json:"private"`
+	Info      string `json:"info"`
+	Link      string
+	OwnerID   int
+	DirectKey string     `json:"-"` // set on direct chats, see DirectKey
+	IsDeleted bool       `json:"isDeleted"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	Members   []User     `json:"members"`
+	Messages  []Message  `json:"messages"`
+	// read state of the user the chat is listed for
+	LastReadMessageID int `json:"lastReadMessageID"`
+	UnreadCount       int `json:"unreadCount"`
+}
+
+// ChatUpdate holds the fields of a chat to change, nil fields are kept.
+type ChatUpdate struct {
+	Name    *string `json:"name"`
+	Info    *string `json:"info"`
+	Private *bool   `json:"private"`
+}
+
+// Apply copies the set fields of the update to the chat.
+func (u *ChatUpdate) Apply(chat *Chat) {
+	if u.Name != nil {
+		chat.Name = *u.Name
+	}
+	if u.Info != nil {
+		chat.Info = *u.Info
+	}
+	if u.Private != nil {
+		chat.Private = *u.Private
+	}
+}
+
+func (chat *Chat) Validate() error {
+	chat.Name = strings.TrimSpace(chat.Name)
+	if chat.Name == "" {
+		return fmt.Errorf("chat name is empty")
+	}
+	if utf8.RuneCountInString(chat.Name) > maxChatNameLength {
+		return fmt.Errorf("chat name must be less than %v symbols", maxChatNameLength)
+	}
+	if utf8.RuneCountInString(chat.Info) > maxChatInfoLength {
+		return fmt.Errorf("chat info must be less than %v symbols", maxChatInfoLength)
+	}
+
+	return nil
 }
 
 func (chat *Chat) GenerateLink() {
 	chat.Link = "join/" + uuid.New().String()
 }
 
+// DirectKey identifies the direct chat of two users, whichever of them opens
+// it.
+func DirectKey(userID int, peerID int) string {
+	if userID > peerID {
+		userID, peerID = peerID, userID
+	}
+
+	return fmt.Sprintf("%d:%d", userID, peerID)
+}
+
+// DirectUserIDs returns the ids of the two users of a direct chat, or nil if
+// the chat isn't one.
+func (chat *Chat) DirectUserIDs() []int {
+	var userID, peerID int
+	if _, err := fmt.Sscanf(chat.DirectKey, "%d:%d", &userID, &peerID); err != nil {
+		return nil
+	}
+
+	return []int{userID, peerID}
+}
+
 func (chat *Chat) GetId() int {
 	return chat.ID
 }
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Invite is a link that lets users join a chat.
+type Invite struct {
+	ID               int        `json:"id"`
+	ChatID           int        `json:"chatID"`
+	CreatorID        int        `json:"creatorID"`
+	Token            string     `json:"token"`
+	ExpiresAt        *time.Time `json:"expiresAt"` // nil if it never expires
+	MaxUses          int        `json:"maxUses"`   // 0 if unlimited
+	Uses             int        `json:"uses"`
+	RequiresApproval bool       `json:"requiresApproval"` // joins wait for an admin
+	IsRevoked        bool       `json:"isRevoked"`
+	CreatedAt        time.Time  `json:"createdAt"`
+}
+
+func (invite *Invite) GenerateToken() {
+	invite.Token = uuid.New().String()
+}
+
+func (invite *Invite) Validate() error {
+	if invite.ExpiresAt != nil && !invite.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("invite expiry must be in the future")
+	}
+	if invite.MaxUses < 0 {
+		return fmt.Errorf("invite max uses must not be negative")
+	}
+
+	return nil
+}
+
+// IsUsable reports whether users can still join with the invite.
+func (invite *Invite) IsUsable(now time.Time) bool {
+	if invite.IsRevoked {
+		return false
+	}
+	if invite.ExpiresAt != nil && !invite.ExpiresAt.After(now) {
+		return false
+	}
+
+	return invite.MaxUses == 0 || invite.Uses < invite.MaxUses
+}
+
+// JoinRequest is a user waiting for an admin to let them in a chat through an
+// invite that requires approval.
+type JoinRequest struct {
+	ChatID    int       `json:"chatID"`
+	UserID    int       `json:"userID"`
+	InviteID  int       `json:"inviteID"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// JoinResult is the outcome of using an invite: either the user is a member
+// of the chat, possibly from before, or their request is pending.
+type JoinResult struct {
+	Chat          *Chat     `json:"chat"`
+	Member        *ChatUser `json:"member,omitempty"`
+	Pending       bool      `json:"pending"`
+	AlreadyMember bool      `json:"alreadyMember"`
+}
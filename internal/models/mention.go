@@ -0,0 +1,45 @@
+package models
+
+import (
+	"regexp"
+	"time"
+)
+
+// Max number of users a single message can mention
+const maxMentions = 20
+
+// @username at the start of the text or after a non-word symbol, so emails
+// aren't taken for mentions. Trailing dots and dashes are punctuation.
+var mentionRgx = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])@([\p{L}\p{N}_]+(?:[.-][\p{L}\p{N}_]+)*)`)
+
+type Mention struct {
+	ID              int       `json:"id"`
+	MessageID       int       `json:"messageID"`
+	ChatID          int       `json:"chatID"`
+	FromID          int       `json:"fromID"` // author of the message
+	MentionedUserID int       `json:"mentionedUserID"`
+	IsSeen          bool      `json:"isSeen"`
+	CreatedAt       time.Time `json:"createdAt"`
+	Message         *Message  `json:"message,omitempty"`
+}
+
+// Mentions returns the usernames mentioned in the text, each of them once.
+func (m *Message) Mentions() []string {
+	var usernames []string
+	seen := make(map[string]bool)
+
+	for _, match := range mentionRgx.FindAllStringSubmatch(m.Text, -1) {
+		username := match[1]
+		if seen[username] {
+			continue
+		}
+		seen[username] = true
+		usernames = append(usernames, username)
+
+		if len(usernames) == maxMentions {
+			break
+		}
+	}
+
+	return usernames
+}
@@ -1,12 +1,33 @@
 package models
 
+import (
+	"fmt"
+	"strings"
+)
+
+const maxMessageLength = 4096
+
 type Message struct {
 	BaseModel
-	Text       string      `json:"text"`
-	UserID     string      `json:"userID"`
-	ChatID     string      `json:"chatID"`
-	ChannelID  string      `json:"channelID"`
-	Attachment *Attachment `json:"attachment"`
+	Text        string         `json:"text"`
+	FromID      int            `json:"fromID"`
+	ChatID      int            `json:"chatID"`
+	ChannelID   string         `json:"channelID"`
+	ReplyToID   int            `json:"replyToID,omitempty"` // message this one replies to
+	ThreadID    int            `json:"threadID,omitempty"`  // root of the thread of a reply
+	Thread      *ThreadSummary `json:"thread,omitempty"`    // set on thread roots
+	Attachments []Attachment   `json:"attachments"`
+	Reactions   []Reaction     `json:"reactions"`
+	IsDeleted   bool           `json:"isDeleted"`
+}
+
+// ReadReceipt is the read position of a chat member. ReadBy is the number of
+// members besides the author that have read the message.
+type ReadReceipt struct {
+	ChatID    int `json:"chatID"`
+	UserID    int `json:"userID"`
+	MessageID int `json:"messageID"`
+	ReadBy    int `json:"readBy"`
 }
 
 type Attachment struct {
@@ -14,5 +35,37 @@ type Attachment struct {
 	Url       string `json:"url"`
 	FileType  string `json:"filetype"`
 	Filename  string `json:"filename"`
-	MessageID string `json:"-"`
+	MessageID int    `json:"-"`
+}
+
+func (m *Message) Validate() error {
+	if strings.TrimSpace(m.Text) == "" && len(m.Attachments) == 0 {
+		return fmt.Errorf("message is empty")
+	}
+	if len(m.Text) > maxMessageLength {
+		return fmt.Errorf("message must be less than %v symbols", maxMessageLength)
+	}
+
+	return nil
+}
+
+// Tombstone returns what is left of the message once it is deleted.
+func (m *Message) Tombstone() *Message {
+	return &Message{
+		BaseModel: m.BaseModel,
+		FromID:    m.FromID,
+		ChatID:    m.ChatID,
+		ReplyToID: m.ReplyToID,
+		ThreadID:  m.ThreadID,
+		IsDeleted: true,
+	}
+}
+
+func (m *Message) AttachmentIDs() []int {
+	ids := make([]int, 0, len(m.Attachments))
+	for _, attachment := range m.Attachments {
+		ids = append(ids, attachment.ID)
+	}
+
+	return ids
 }
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Moderation actions of chat owners and admins
+const (
+	ModerationKick    = "kick"
+	ModerationBan     = "ban"
+	ModerationUnban   = "unban"
+	ModerationMute    = "mute"
+	ModerationUnmute  = "unmute"
+	ModerationPromote = "promote" // make an admin
+	ModerationDemote  = "demote"  // make a regular member
+)
+
+// Longest timed ban or mute
+const maxModerationDuration = 366 * 24 * time.Hour
+
+// Moderation is an action taken on a chat member.
+type Moderation struct {
+	Action   string    `json:"action"`
+	ChatID   int       `json:"chatID"`
+	UserID   int       `json:"userID"`             // member the action is taken on
+	ActorID  int       `json:"actorID"`            // owner or admin taking it
+	Duration int       `json:"duration,omitempty"` // seconds of a ban or mute, a ban without one is permanent
+	Member   *ChatUser `json:"member,omitempty"`   // member after the action, unless they were removed
+}
+
+func (m *Moderation) Validate() error {
+	switch m.Action {
+	case ModerationKick, ModerationBan, ModerationUnban, ModerationMute,
+		ModerationUnmute, ModerationPromote, ModerationDemote:
+	default:
+		return fmt.Errorf("unknown moderation action %q", m.Action)
+	}
+
+	if m.Duration < 0 || time.Duration(m.Duration)*time.Second > maxModerationDuration {
+		return fmt.Errorf("duration must be between 0 and %v seconds", int(maxModerationDuration.Seconds()))
+	}
+	if m.Action == ModerationMute && m.Duration == 0 {
+		return fmt.Errorf("mute duration is required")
+	}
+
+	return nil
+}
+
+// RemovesMember reports whether the member loses access to the chat.
+func (m *Moderation) RemovesMember() bool {
+	return m.Action == ModerationKick || m.Action == ModerationBan
+}
+
+// ChangesRole reports whether the action is a promotion or a demotion.
+func (m *Moderation) ChangesRole() bool {
+	return m.Action == ModerationPromote || m.Action == ModerationDemote
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Max size of an emoji in bytes, long enough for ZWJ sequences
+const maxEmojiLength = 32
+
+// Reaction is the aggregate of the reactions to a message with one emoji.
+type Reaction struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	UserIDs []int  `json:"userIDs"` // in the order they reacted
+}
+
+// ReactionsUpdate carries all the reactions to a message after one of them
+// changed.
+type ReactionsUpdate struct {
+	ChatID    int        `json:"chatID"`
+	MessageID int        `json:"messageID"`
+	UserID    int        `json:"userID"` // user who reacted
+	Emoji     string     `json:"emoji"`
+	Added     bool       `json:"added"`
+	Reactions []Reaction `json:"reactions"`
+}
+
+// ValidateEmoji accepts a single emoji, possibly made of several code points,
+// and rejects text.
+func ValidateEmoji(emoji string) error {
+	if emoji == "" {
+		return fmt.Errorf("emoji is empty")
+	}
+	if len(emoji) > maxEmojiLength || !utf8.ValidString(emoji) {
+		return fmt.Errorf("invalid emoji")
+	}
+
+	for _, r := range emoji {
+		if unicode.IsLetter(r) || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return fmt.Errorf("invalid emoji")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// ThreadSummary describes the replies to a message.
+type ThreadSummary struct {
+	ChatID         int       `json:"chatID"`
+	RootID         int       `json:"rootID"`
+	ReplyCount     int       `json:"replyCount"`
+	LastReplyID    int       `json:"lastReplyID"`
+	LastReplyAt    time.Time `json:"lastReplyAt"`
+	ParticipantIDs []int     `json:"participantIDs"` // author of the root and users who replied
+}
+
+// Thread is a page of the replies to a message, oldest first.
+type Thread struct {
+	Root    *Message  `json:"root"`
+	Replies []Message `json:"replies"`
+}
@@ -31,6 +31,32 @@ type ChatUser struct {
 	IsOnline   bool      `json:"isOnline"`
 	JoinedAt   time.Time `json:"joinedAt"`
 	IsBanned   bool
+	// set while a timed ban or a mute lasts
+	BannedUntil *time.Time `json:"bannedUntil,omitempty"`
+	MutedUntil  *time.Time `json:"mutedUntil,omitempty"`
+}
+
+// IsMuted reports whether the member may not send messages for now.
+func (u *ChatUser) IsMuted() bool {
+	return u.MutedUntil != nil && u.MutedUntil.After(time.Now())
+}
+
+// Profile returns the membership with the public part of the user only, safe
+// to show to other chat members.
+func (u *ChatUser) Profile() *ChatUser {
+	return &ChatUser{
+		ID:          u.ID,
+		Role:        u.Role,
+		Name:        u.Name,
+		Lastname:    u.Lastname,
+		Patronymic:  u.Patronymic,
+		Tag:         u.Tag,
+		Username:    u.Username,
+		JoinedAt:    u.JoinedAt,
+		IsBanned:    u.IsBanned,
+		BannedUntil: u.BannedUntil,
+		MutedUntil:  u.MutedUntil,
+	}
 }
 
 var (
@@ -51,6 +77,7 @@ type User struct {
 	Password   string    `json:"password"`
 	Info       string    `json:"info"`
 	IsOnline   bool      `json:"isOnline"`
+	Presence   string    `json:"presence,omitempty"` // online, away, offline
 	CreatedAt  time.Time `json:"createdAt"`
 	UpdatedAt  time.Time `json:"updatedAt"`
 }
@@ -59,6 +86,19 @@ func (u *User) Sanitaze() {
 	u.Password = ""
 }
 
+// Profile returns the public part of the user that is safe to show to other
+// chat members.
+func (u *User) Profile() *User {
+	return &User{
+		ID:         u.ID,
+		Name:       u.Name,
+		Lastname:   u.Lastname,
+		Patronymic: u.Patronymic,
+		Tag:        u.Tag,
+		Username:   u.Username,
+	}
+}
+
 type UserRegister struct {
 	Name       string `json:"name"`
 	Lastname   string `json:"lastname"`
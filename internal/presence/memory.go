@@ -0,0 +1,103 @@
+package presence
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryStore struct {
+	mu    sync.Mutex
+	users map[int]map[string]connection
+}
+
+func NewMemoryStore() *memoryStore {
+	return &memoryStore{
+		users: make(map[int]map[string]connection),
+	}
+}
+
+func (s *memoryStore) Touch(ctx context.Context, userID int, connID string, status string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		s.users[userID] = make(map[string]connection)
+	}
+	s.users[userID][connID] = connection{
+		status:    status,
+		expiresAt: time.Now().Add(ttl),
+	}
+
+	return nil
+}
+
+func (s *memoryStore) TouchAll(ctx context.Context, beats []Heartbeat, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt := time.Now().Add(ttl)
+	for _, beat := range beats {
+		if _, ok := s.users[beat.UserID]; !ok {
+			s.users[beat.UserID] = make(map[string]connection)
+		}
+		s.users[beat.UserID][beat.ConnID] = connection{
+			status:    beat.Status,
+			expiresAt: expiresAt,
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) Remove(ctx context.Context, userID int, connID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if connections, ok := s.users[userID]; ok {
+		delete(connections, connID)
+		if len(connections) == 0 {
+			delete(s.users, userID)
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) Status(ctx context.Context, userIDs []int) (map[int]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	statuses := make(map[int]string, len(userIDs))
+	for _, userID := range userIDs {
+		statuses[userID] = aggregate(s.connections(userID), now)
+	}
+
+	return statuses, nil
+}
+
+func (s *memoryStore) Expired(ctx context.Context) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var expired []int
+	for userID := range s.users {
+		if aggregate(s.connections(userID), now) == StatusOffline {
+			delete(s.users, userID)
+			expired = append(expired, userID)
+		}
+	}
+
+	return expired, nil
+}
+
+func (s *memoryStore) connections(userID int) []connection {
+	connections := make([]connection, 0, len(s.users[userID]))
+	for _, conn := range s.users[userID] {
+		connections = append(connections, conn)
+	}
+
+	return connections
+}
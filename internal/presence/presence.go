@@ -0,0 +1,67 @@
+package presence
+
+import (
+	"chatie/internal/broker"
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	StatusOnline  = "online"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+// Store keeps the status of every connection of a user until its ttl runs
+// out. The status of a user is the best status of their live connections.
+type Store interface {
+	Touch(ctx context.Context, userID int, connID string, status string, ttl time.Duration) error
+	// TouchAll extends the ttl of many connections at once.
+	TouchAll(ctx context.Context, beats []Heartbeat, ttl time.Duration) error
+	Remove(ctx context.Context, userID int, connID string) error
+	Status(ctx context.Context, userIDs []int) (map[int]string, error)
+	// Expired returns the users whose last connection has timed out since
+	// the previous call. Each user is reported to a single caller only.
+	Expired(ctx context.Context) ([]int, error)
+}
+
+// NewStore keeps presence next to the broker: in redis when the nodes share
+// one, in process memory otherwise.
+func NewStore(b broker.Broker) Store {
+	if redisBroker, ok := b.(interface{ Client() *redis.Client }); ok {
+		return NewRedisStore(redisBroker.Client())
+	}
+
+	return NewMemoryStore()
+}
+
+// Heartbeat is the status of a live connection.
+type Heartbeat struct {
+	UserID int
+	ConnID string
+	Status string
+}
+
+type connection struct {
+	status    string
+	expiresAt time.Time
+}
+
+// aggregate returns the status of a user from the statuses of their
+// connections that are still alive.
+func aggregate(connections []connection, now time.Time) string {
+	status := StatusOffline
+	for _, conn := range connections {
+		if !conn.expiresAt.After(now) {
+			continue
+		}
+		if conn.status == StatusOnline {
+			return StatusOnline
+		}
+		status = StatusAway
+	}
+
+	return status
+}
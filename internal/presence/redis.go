@@ -0,0 +1,177 @@
+package presence
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// onlineKey holds every user with a live connection, scored by the time the
+// last of their connections expires.
+const onlineKey = "presence:online"
+
+// Number of connections touched per pipeline by TouchAll
+const touchBatchSize = 1000
+
+type redisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *redisStore {
+	return &redisStore{client: client}
+}
+
+func userKey(userID int) string {
+	return fmt.Sprintf("presence:user:%v", userID)
+}
+
+func (s *redisStore) Touch(ctx context.Context, userID int, connID string, status string, ttl time.Duration) error {
+	pipe := s.client.TxPipeline()
+	touch(ctx, pipe, Heartbeat{UserID: userID, ConnID: connID, Status: status}, time.Now().Add(ttl), ttl)
+	_, err := pipe.Exec(ctx)
+
+	return err
+}
+
+// TouchAll sends the heartbeats in pipelines of touchBatchSize connections,
+// a round trip per batch instead of one per connection.
+func (s *redisStore) TouchAll(ctx context.Context, beats []Heartbeat, ttl time.Duration) error {
+	expiresAt := time.Now().Add(ttl)
+
+	for start := 0; start < len(beats); start += touchBatchSize {
+		end := start + touchBatchSize
+		if end > len(beats) {
+			end = len(beats)
+		}
+
+		pipe := s.client.Pipeline()
+		for _, beat := range beats[start:end] {
+			touch(ctx, pipe, beat, expiresAt, ttl)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func touch(ctx context.Context, pipe redis.Pipeliner, beat Heartbeat, expiresAt time.Time, ttl time.Duration) {
+	pipe.HSet(ctx, userKey(beat.UserID), beat.ConnID, fmt.Sprintf("%s|%d", beat.Status, expiresAt.UnixMilli()))
+	pipe.Expire(ctx, userKey(beat.UserID), ttl)
+	pipe.ZAddGT(ctx, onlineKey, redis.Z{
+		Score:  float64(expiresAt.UnixMilli()),
+		Member: beat.UserID,
+	})
+}
+
+func (s *redisStore) Remove(ctx context.Context, userID int, connID string) error {
+	if err := s.client.HDel(ctx, userKey(userID), connID).Err(); err != nil {
+		return err
+	}
+
+	connections, err := s.connections(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// move the user's expiry back to the last live connection
+	var expiresAt time.Time
+	for _, conn := range connections {
+		if conn.expiresAt.After(expiresAt) {
+			expiresAt = conn.expiresAt
+		}
+	}
+
+	if aggregate(connections, time.Now()) == StatusOffline {
+		return s.client.ZRem(ctx, onlineKey, userID).Err()
+	}
+
+	return s.client.ZAdd(ctx, onlineKey, redis.Z{
+		Score:  float64(expiresAt.UnixMilli()),
+		Member: userID,
+	}).Err()
+}
+
+func (s *redisStore) Status(ctx context.Context, userIDs []int) (map[int]string, error) {
+	pipe := s.client.Pipeline()
+	commands := make([]*redis.MapStringStringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		commands[i] = pipe.HGetAll(ctx, userKey(userID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	statuses := make(map[int]string, len(userIDs))
+	for i, userID := range userIDs {
+		statuses[userID] = aggregate(parseConnections(commands[i].Val()), now)
+	}
+
+	return statuses, nil
+}
+
+func (s *redisStore) Expired(ctx context.Context) ([]int, error) {
+	members, err := s.client.ZRangeByScore(ctx, onlineKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []int
+	for _, member := range members {
+		userID, err := strconv.Atoi(member)
+		if err != nil {
+			continue
+		}
+
+		// only the node that manages to remove the user reports it
+		removed, err := s.client.ZRem(ctx, onlineKey, member).Result()
+		if err != nil {
+			return nil, err
+		}
+		if removed == 1 {
+			expired = append(expired, userID)
+		}
+	}
+
+	return expired, nil
+}
+
+func (s *redisStore) connections(ctx context.Context, userID int) ([]connection, error) {
+	values, err := s.client.HGetAll(ctx, userKey(userID)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	return parseConnections(values), nil
+}
+
+func parseConnections(values map[string]string) []connection {
+	connections := make([]connection, 0, len(values))
+	for _, value := range values {
+		status, expiresAt, ok := strings.Cut(value, "|")
+		if !ok {
+			continue
+		}
+
+		milli, err := strconv.ParseInt(expiresAt, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		connections = append(connections, connection{
+			status:    status,
+			expiresAt: time.UnixMilli(milli),
+		})
+	}
+
+	return connections
+}
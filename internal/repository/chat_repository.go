@@ -5,6 +5,7 @@ import (
 	"chatie/internal/models"
 	"context"
 	"errors"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -20,57 +21,141 @@ func NewChatRepository(db *pgxpool.Pool) *chatRepo {
 	return &chatRepo{db: db}
 }
 
+// Create stores the chat with the user as its owner and only member.
 func (r *chatRepo) Create(ctx context.Context, chat *models.Chat, userID int) (*models.Chat, error) {
-	tx, err := r.db.BeginTx(context.TODO(), pgx.TxOptions{})
+	queryChat := `
+		INSERT INTO 
+			chats(owner_id, name, info, is_private, link) 
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING chat_id, created_at, updated_at`
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback(context.Background())
-
-	queryUsers := `
-		INSERT INTO 
-			chats(owner_id, name, info, is_private, link) 
-		VALUES ($1, $2, $3, $4, $5) RETURNING chat_id`
+	defer tx.Rollback(ctx)
 
-	_, err = tx.Prepare(ctx, "InsertIntoChats", queryUsers)
+	err = tx.QueryRow(ctx, queryChat,
+		userID, chat.Name, chat.Info, chat.Private, chat.Link).Scan(
+		&chat.ID,
+		&chat.CreatedAt,
+		&chat.UpdatedAt,
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	var chatID int
-	err = tx.QueryRow(ctx, "InsertIntoChats",
-		userID, chat.Name, chat.Info, chat.Private, chat.Link).Scan(&chatID)
+	queryMember := `
+		INSERT INTO
+			chat_members(chat_id, user_id, user_role)
+		VALUES ($1, $2, $3)`
+
+	_, err = tx.Exec(ctx, queryMember, chat.ID, userID, models.UserOwner)
 	if err != nil {
-		if isDuplicateError(err) {
-			return nil, apperror.ErrUserExists
-		}
 		return nil, err
 	}
 
-	queryChatMembers := `INSERT INTO chat_members(chat_id, user_id, user_role) VALUES ($1, $2, $3)`
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	chat.OwnerID = userID
+
+	return chat, nil
+}
+
+// GetOrCreateDirect returns the direct chat of the two users. The first call
+// for a pair creates the chat with both users as members, the unique key of
+// the pair makes concurrent calls end up with the same chat.
+func (r *chatRepo) GetOrCreateDirect(ctx context.Context, userID int, peerID int) (*models.Chat, error) {
+	directKey := models.DirectKey(userID, peerID)
+
+	chat := models.Chat{Private: true}
+	chat.GenerateLink()
+
+	queryChat := `
+		INSERT INTO
+			chats(owner_id, name, is_private, link, direct_key)
+		VALUES ($1, '', true, $2, $3)
+		ON CONFLICT (direct_key) DO NOTHING
+		RETURNING chat_id`
 
-	_, err = tx.Prepare(ctx, "InsertIntoMemmbers", queryChatMembers)
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback(ctx)
 
-	_, err = tx.Exec(ctx, "InsertIntoMembers", chat.ID, userID, models.UserOwner)
+	var chatID int
+	err = tx.QueryRow(ctx, queryChat, userID, chat.Link, directKey).Scan(&chatID)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return r.getByDirectKey(ctx, directKey)
+		}
 		return nil, err
 	}
 
-	err = tx.Commit(context.Background())
+	queryMembers := `
+		INSERT INTO
+			chat_members(chat_id, user_id, user_role)
+		VALUES
+			($1, $2, $4), ($1, $3, $4)`
+
+	_, err = tx.Exec(ctx, queryMembers, chatID, userID, peerID, models.UserDefault)
 	if err != nil {
 		return nil, err
 	}
 
-	chat.ID = chatID
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
 
-	return chat, nil
+	return r.GetByID(ctx, chatID)
+}
+
+func (r *chatRepo) getByDirectKey(ctx context.Context, directKey string) (*models.Chat, error) {
+	query := `
+		SELECT
+			chat_id
+		FROM
+			chats
+		WHERE
+			direct_key = $1`
+
+	var chatID int
+	if err := r.db.QueryRow(ctx, query, directKey).Scan(&chatID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperror.ErrChatNotFound
+		}
+		return nil, err
+	}
+
+	return r.GetByID(ctx, chatID)
 }
 
+// Update saves the name, info and privacy of the chat.
 func (r *chatRepo) Update(ctx context.Context, chat *models.Chat) (*models.Chat, error) {
-	return nil, nil
+	query := `
+		UPDATE
+			chats
+		SET
+			name = $2,
+			info = $3,
+			is_private = $4,
+			updated_at = now()
+		WHERE
+			chat_id = $1 AND is_deleted = false
+		RETURNING updated_at`
+
+	err := r.db.QueryRow(ctx, query, chat.ID, chat.Name, chat.Info, chat.Private).Scan(&chat.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperror.ErrChatNotFound
+		}
+		return nil, err
+	}
+
+	return chat, nil
 }
 
 func (r *chatRepo) AddUserToChat(ctx context.Context, chatID int, userID int, userRole string) error {
@@ -100,8 +185,10 @@ func (r *chatRepo) GetChatMemberByID(ctx context.Context, chatID int, userID int
 			u.info,
 			u.email,
 			cm.user_role,
-			cm.is_banned,
-			cm.joined_at
+			cm.is_banned AND (cm.banned_until IS NULL OR cm.banned_until > now()),
+			cm.joined_at,
+			CASE WHEN cm.is_banned AND cm.banned_until > now() THEN cm.banned_until END,
+			CASE WHEN cm.muted_until > now() THEN cm.muted_until END
 		FROM
 			users as u
 		JOIN
@@ -123,6 +210,8 @@ func (r *chatRepo) GetChatMemberByID(ctx context.Context, chatID int, userID int
 		&chatUser.Role,
 		&chatUser.IsBanned,
 		&chatUser.JoinedAt,
+		&chatUser.BannedUntil,
+		&chatUser.MutedUntil,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -146,8 +235,10 @@ func (r *chatRepo) GetChatMembersByID(ctx context.Context, chatID int) ([]models
 			u.info,
 			u.email,
 			cm.user_role,
-			cm.is_banned,
-			cm.joined_at
+			cm.is_banned AND (cm.banned_until IS NULL OR cm.banned_until > now()),
+			cm.joined_at,
+			CASE WHEN cm.is_banned AND cm.banned_until > now() THEN cm.banned_until END,
+			CASE WHEN cm.muted_until > now() THEN cm.muted_until END
 		FROM
 			users AS u
 		JOIN
@@ -178,6 +269,8 @@ func (r *chatRepo) GetChatMembersByID(ctx context.Context, chatID int) ([]models
 			&chatUser.Role,
 			&chatUser.IsBanned,
 			&chatUser.JoinedAt,
+			&chatUser.BannedUntil,
+			&chatUser.MutedUntil,
 		)
 		if err != nil {
 			return nil, err
@@ -201,7 +294,11 @@ func (r *chatRepo) GetByID(ctx context.Context, chatID int) (*models.Chat, error
 			info, 
 			is_private, 
 			link,
-			created_at
+			COALESCE(direct_key, ''),
+			COALESCE(is_deleted, false),
+			deleted_at,
+			created_at,
+			updated_at
 		FROM
 			chats
 		WHERE
@@ -216,11 +313,15 @@ func (r *chatRepo) GetByID(ctx context.Context, chatID int) (*models.Chat, error
 		&chat.Info,
 		&chat.Private,
 		&chat.Link,
+		&chat.DirectKey,
+		&chat.IsDeleted,
+		&chat.DeletedAt,
 		&chat.CreatedAt,
+		&chat.UpdatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, apperror.ErrChatMemberNotFound
+			return nil, apperror.ErrChatNotFound
 		}
 		return nil, err
 	}
@@ -229,19 +330,42 @@ func (r *chatRepo) GetByID(ctx context.Context, chatID int) (*models.Chat, error
 
 }
 
+// GetAllChatsByUserID returns the chats the user is a member of along with
+// the user's read position and the number of unread messages. Deleted chats
+// are left out.
 func (r *chatRepo) GetAllChatsByUserID(ctx context.Context, userID int) ([]models.Chat, error) {
 	query := `
 		SELECT
-			chat_id,
-			name, 
-			info, 
-			is_private, 
-			link,
-			created_at
+			c.chat_id,
+			c.owner_id,
+			c.name, 
+			c.info, 
+			c.is_private, 
+			c.link,
+			COALESCE(c.direct_key, ''),
+			c.created_at,
+			cm.last_read_message_id,
+			(
+				SELECT 
+					COUNT(*) 
+				FROM 
+					messages AS m
+				WHERE 
+					m.chat_id = c.chat_id 
+					AND m.message_id > cm.last_read_message_id
+					AND m.from_id <> cm.user_id
+					AND m.is_deleted = false
+			)
 		FROM
-			chats
+			chats AS c
+		JOIN
+			chat_members AS cm
+		ON
+			c.chat_id = cm.chat_id
 		WHERE
-			owner_id = $1`
+			cm.user_id = $1 AND c.is_deleted = false
+		ORDER BY
+			c.chat_id`
 
 	rows, err := r.db.Query(ctx, query, userID)
 	if err != nil {
@@ -254,10 +378,15 @@ func (r *chatRepo) GetAllChatsByUserID(ctx context.Context, userID int) ([]model
 		var chat models.Chat
 		err = rows.Scan(
 			&chat.ID,
+			&chat.OwnerID,
 			&chat.Name,
 			&chat.Info,
+			&chat.Private,
 			&chat.Link,
+			&chat.DirectKey,
 			&chat.CreatedAt,
+			&chat.LastReadMessageID,
+			&chat.UnreadCount,
 		)
 		if err != nil {
 			return nil, err
@@ -270,3 +399,220 @@ func (r *chatRepo) GetAllChatsByUserID(ctx context.Context, userID int) ([]model
 
 	return chats, nil
 }
+
+// GetChatPartnerIDs returns the ids of the users that share at least one chat
+// that isn't deleted with the given user.
+func (r *chatRepo) GetChatPartnerIDs(ctx context.Context, userID int) ([]int, error) {
+	query := `
+		SELECT DISTINCT
+			partner.user_id
+		FROM
+			chat_members AS cm
+		JOIN
+			chat_members AS partner
+		ON
+			cm.chat_id = partner.chat_id
+		JOIN
+			chats AS c
+		ON
+			c.chat_id = cm.chat_id
+		WHERE
+			cm.user_id = $1 AND partner.user_id <> $1 AND c.is_deleted = false`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var partnerID int
+		if err := rows.Scan(&partnerID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, partnerID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return userIDs, nil
+}
+
+// RemoveMember deletes the membership of the user, along with their role and
+// read position. A member under an active ban is kept, as the ban lives on
+// the membership row.
+func (r *chatRepo) RemoveMember(ctx context.Context, chatID int, userID int) error {
+	query := `
+		DELETE FROM
+			chat_members
+		WHERE
+			chat_id = $1 AND user_id = $2
+			AND NOT (COALESCE(is_banned, false) AND (banned_until IS NULL OR banned_until > now()))`
+
+	return r.execMemberUpdate(ctx, query, chatID, userID)
+}
+
+// BanMember bans the member for the given number of seconds, or for good if
+// it is 0.
+func (r *chatRepo) BanMember(ctx context.Context, chatID int, userID int, seconds int) error {
+	query := `
+		UPDATE
+			chat_members
+		SET
+			is_banned = true,
+			banned_at = now(),
+			banned_until = CASE WHEN $3::int > 0 THEN now() + make_interval(secs => $3::int) END
+		WHERE
+			chat_id = $1 AND user_id = $2`
+
+	return r.execMemberUpdate(ctx, query, chatID, userID, seconds)
+}
+
+func (r *chatRepo) UnbanMember(ctx context.Context, chatID int, userID int) error {
+	query := `
+		UPDATE
+			chat_members
+		SET
+			is_banned = false,
+			banned_until = NULL
+		WHERE
+			chat_id = $1 AND user_id = $2`
+
+	return r.execMemberUpdate(ctx, query, chatID, userID)
+}
+
+// MuteMember keeps the member from sending messages for the given number of
+// seconds, 0 lifts the mute.
+func (r *chatRepo) MuteMember(ctx context.Context, chatID int, userID int, seconds int) error {
+	query := `
+		UPDATE
+			chat_members
+		SET
+			muted_until = CASE WHEN $3::int > 0 THEN now() + make_interval(secs => $3::int) END
+		WHERE
+			chat_id = $1 AND user_id = $2`
+
+	return r.execMemberUpdate(ctx, query, chatID, userID, seconds)
+}
+
+func (r *chatRepo) SetMemberRole(ctx context.Context, chatID int, userID int, role string) error {
+	query := `
+		UPDATE
+			chat_members
+		SET
+			user_role = $3
+		WHERE
+			chat_id = $1 AND user_id = $2`
+
+	return r.execMemberUpdate(ctx, query, chatID, userID, role)
+}
+
+func (r *chatRepo) execMemberUpdate(ctx context.Context, query string, args ...any) error {
+	tag, err := r.db.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrChatMemberNotFound
+	}
+
+	return nil
+}
+
+// TransferOwnership makes the member the owner of the chat, the previous
+// owner stays on as an admin.
+func (r *chatRepo) TransferOwnership(ctx context.Context, chatID int, ownerID int, newOwnerID int) error {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	queryChat := `
+		UPDATE
+			chats
+		SET
+			owner_id = $3,
+			updated_at = now()
+		WHERE
+			chat_id = $1 AND owner_id = $2 AND is_deleted = false`
+
+	tag, err := tx.Exec(ctx, queryChat, chatID, ownerID, newOwnerID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrChatNotFound
+	}
+
+	queryRole := `
+		UPDATE
+			chat_members
+		SET
+			user_role = $3
+		WHERE
+			chat_id = $1 AND user_id = $2`
+
+	if _, err := tx.Exec(ctx, queryRole, chatID, ownerID, models.UserAdmin); err != nil {
+		return err
+	}
+
+	tag, err = tx.Exec(ctx, queryRole, chatID, newOwnerID, models.UserOwner)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrChatMemberNotFound
+	}
+
+	return tx.Commit(ctx)
+}
+
+// SoftDelete marks the chat as deleted, its members and messages are kept so
+// it can be restored.
+func (r *chatRepo) SoftDelete(ctx context.Context, chatID int) (*models.Chat, error) {
+	query := `
+		UPDATE
+			chats
+		SET
+			is_deleted = true,
+			deleted_at = now()
+		WHERE
+			chat_id = $1 AND is_deleted = false`
+
+	tag, err := r.db.Exec(ctx, query, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, apperror.ErrChatNotFound
+	}
+
+	return r.GetByID(ctx, chatID)
+}
+
+// Restore undoes the deletion of a chat deleted less than window ago.
+func (r *chatRepo) Restore(ctx context.Context, chatID int, window time.Duration) (*models.Chat, error) {
+	query := `
+		UPDATE
+			chats
+		SET
+			is_deleted = false,
+			deleted_at = NULL
+		WHERE
+			chat_id = $1 AND is_deleted = true
+			AND deleted_at > now() - make_interval(secs => $2::double precision)`
+
+	tag, err := r.db.Exec(ctx, query, chatID, window.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, apperror.ErrChatNotFound
+	}
+
+	return r.GetByID(ctx, chatID)
+}
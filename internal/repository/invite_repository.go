@@ -0,0 +1,257 @@
+package repository
+
+import (
+	"chatie/internal/apperror"
+	"chatie/internal/models"
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type inviteRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewInviteRepository(db *pgxpool.Pool) *inviteRepo {
+	return &inviteRepo{db: db}
+}
+
+const inviteColumns = `
+			invite_id,
+			chat_id,
+			creator_id,
+			token,
+			expires_at,
+			max_uses,
+			uses,
+			requires_approval,
+			is_revoked,
+			created_at`
+
+func scanInvite(row pgx.Row) (*models.Invite, error) {
+	var invite models.Invite
+	err := row.Scan(
+		&invite.ID,
+		&invite.ChatID,
+		&invite.CreatorID,
+		&invite.Token,
+		&invite.ExpiresAt,
+		&invite.MaxUses,
+		&invite.Uses,
+		&invite.RequiresApproval,
+		&invite.IsRevoked,
+		&invite.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &invite, nil
+}
+
+func (r *inviteRepo) Create(ctx context.Context, invite *models.Invite) (*models.Invite, error) {
+	query := `
+		INSERT INTO
+			chat_invites(chat_id, creator_id, token, expires_at, max_uses, requires_approval)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING invite_id, created_at`
+
+	err := r.db.QueryRow(ctx, query,
+		invite.ChatID, invite.CreatorID, invite.Token, invite.ExpiresAt, invite.MaxUses, invite.RequiresApproval).Scan(
+		&invite.ID,
+		&invite.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return invite, nil
+}
+
+func (r *inviteRepo) GetByToken(ctx context.Context, token string) (*models.Invite, error) {
+	query := `
+		SELECT` + inviteColumns + `
+		FROM
+			chat_invites
+		WHERE
+			token = $1`
+
+	invite, err := scanInvite(r.db.QueryRow(ctx, query, token))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperror.ErrInviteNotFound
+		}
+		return nil, err
+	}
+
+	return invite, nil
+}
+
+// GetByChatID returns the invites of the chat, newest first.
+func (r *inviteRepo) GetByChatID(ctx context.Context, chatID int) ([]models.Invite, error) {
+	query := `
+		SELECT` + inviteColumns + `
+		FROM
+			chat_invites
+		WHERE
+			chat_id = $1
+		ORDER BY
+			invite_id DESC`
+
+	rows, err := r.db.Query(ctx, query, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []models.Invite{}
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, *invite)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invites, nil
+}
+
+// Revoke marks the invite of the chat as revoked.
+func (r *inviteRepo) Revoke(ctx context.Context, chatID int, inviteID int) (*models.Invite, error) {
+	query := `
+		UPDATE
+			chat_invites
+		SET
+			is_revoked = true
+		WHERE
+			chat_id = $1 AND invite_id = $2
+		RETURNING` + inviteColumns
+
+	invite, err := scanInvite(r.db.QueryRow(ctx, query, chatID, inviteID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperror.ErrInviteNotFound
+		}
+		return nil, err
+	}
+
+	return invite, nil
+}
+
+// Use counts a use of the invite if it is still usable. The check and the
+// count are a single statement, so concurrent joins can't go over max uses.
+func (r *inviteRepo) Use(ctx context.Context, inviteID int) error {
+	query := `
+		UPDATE
+			chat_invites
+		SET
+			uses = uses + 1
+		WHERE
+			invite_id = $1
+			AND is_revoked = false
+			AND (expires_at IS NULL OR expires_at > now())
+			AND (max_uses = 0 OR uses < max_uses)`
+
+	tag, err := r.db.Exec(ctx, query, inviteID)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrInviteExpired
+	}
+
+	return nil
+}
+
+// CreateJoinRequest stores the request of the user to join the chat, a
+// pending request of the user is kept as is. It reports whether the request
+// is new.
+func (r *inviteRepo) CreateJoinRequest(ctx context.Context, request *models.JoinRequest) (bool, error) {
+	query := `
+		INSERT INTO
+			chat_join_requests(chat_id, user_id, invite_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+		RETURNING created_at`
+
+	err := r.db.QueryRow(ctx, query, request.ChatID, request.UserID, request.InviteID).Scan(&request.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetJoinRequests returns the pending requests to join the chat, oldest
+// first.
+func (r *inviteRepo) GetJoinRequests(ctx context.Context, chatID int) ([]models.JoinRequest, error) {
+	query := `
+		SELECT
+			chat_id,
+			user_id,
+			invite_id,
+			created_at
+		FROM
+			chat_join_requests
+		WHERE
+			chat_id = $1
+		ORDER BY
+			created_at`
+
+	rows, err := r.db.Query(ctx, query, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []models.JoinRequest{}
+	for rows.Next() {
+		var request models.JoinRequest
+		err := rows.Scan(
+			&request.ChatID,
+			&request.UserID,
+			&request.InviteID,
+			&request.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// DeleteJoinRequest removes the request of the user to join the chat.
+func (r *inviteRepo) DeleteJoinRequest(ctx context.Context, chatID int, userID int) error {
+	query := `
+		DELETE FROM
+			chat_join_requests
+		WHERE
+			chat_id = $1 AND user_id = $2`
+
+	tag, err := r.db.Exec(ctx, query, chatID, userID)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return apperror.ErrJoinRequestNotFound
+	}
+
+	return nil
+}
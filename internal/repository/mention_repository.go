@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"chatie/internal/models"
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type mentionRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewMentionRepository(db *pgxpool.Pool) *mentionRepo {
+	return &mentionRepo{db: db}
+}
+
+// Create stores the mentions of a message and stamps them with their ids.
+func (r *mentionRepo) Create(ctx context.Context, mentions []models.Mention) ([]models.Mention, error) {
+	query := `
+		INSERT INTO
+			mentions(user_id, mentioned_user_id, message_id)
+		VALUES ($1, $2, $3)
+		RETURNING mention_id, created_at`
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	for i := range mentions {
+		mention := &mentions[i]
+		err := tx.QueryRow(ctx, query,
+			mention.FromID, mention.MentionedUserID, mention.MessageID).Scan(
+			&mention.ID,
+			&mention.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return mentions, nil
+}
+
+// GetUnseen returns up to limit unseen mentions of the user along with their
+// messages, newest first. Mentions in deleted messages or chats, and in chats
+// the user is no longer a member of or is banned from, are left out.
+func (r *mentionRepo) GetUnseen(ctx context.Context, userID int, limit int) ([]models.Mention, error) {
+	query := `
+		SELECT
+			mn.mention_id,
+			mn.message_id,
+			m.chat_id,
+			mn.user_id,
+			mn.mentioned_user_id,
+			mn.is_seen,
+			mn.created_at,
+			COALESCE(m.text, ''),
+			m.created_at,
+			m.updated_at
+		FROM
+			mentions AS mn
+		JOIN
+			messages AS m
+		ON
+			m.message_id = mn.message_id
+		JOIN
+			chats AS c
+		ON
+			c.chat_id = m.chat_id
+		JOIN
+			chat_members AS cm
+		ON
+			cm.chat_id = m.chat_id AND cm.user_id = mn.mentioned_user_id
+		WHERE
+			mn.mentioned_user_id = $1 AND mn.is_seen = false AND m.is_deleted = false
+			AND c.is_deleted = false
+			AND NOT (COALESCE(cm.is_banned, false) AND (cm.banned_until IS NULL OR cm.banned_until > now()))
+		ORDER BY
+			mn.mention_id DESC
+		LIMIT $2`
+
+	rows, err := r.db.Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := []models.Mention{}
+	for rows.Next() {
+		var mention models.Mention
+		var message models.Message
+		err := rows.Scan(
+			&mention.ID,
+			&mention.MessageID,
+			&mention.ChatID,
+			&mention.FromID,
+			&mention.MentionedUserID,
+			&mention.IsSeen,
+			&mention.CreatedAt,
+			&message.Text,
+			&message.CreatedAt,
+			&message.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		message.ID = mention.MessageID
+		message.ChatID = mention.ChatID
+		message.FromID = mention.FromID
+		mention.Message = &message
+
+		mentions = append(mentions, mention)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return mentions, nil
+}
+
+// MarkSeen marks the mentions of the user as seen and returns how many were
+// updated.
+func (r *mentionRepo) MarkSeen(ctx context.Context, userID int, mentionIDs []int) (int, error) {
+	query := `
+		UPDATE
+			mentions
+		SET
+			is_seen = true,
+			updated_at = now()
+		WHERE
+			mentioned_user_id = $1 AND mention_id = ANY($2) AND is_seen = false`
+
+	tag, err := r.db.Exec(ctx, query, userID, mentionIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
@@ -1 +1,620 @@
 package repository
+
+import (
+	"chatie/internal/apperror"
+	"chatie/internal/models"
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type messageRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewMessageRepository(db *pgxpool.Pool) *messageRepo {
+	return &messageRepo{db: db}
+}
+
+func (r *messageRepo) Create(ctx context.Context, message *models.Message) (*models.Message, error) {
+	query := `
+		INSERT INTO 
+			messages(text, from_id, chat_id, attachment_ids, reply_to_id, thread_id) 
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0), NULLIF($6, 0)) 
+		RETURNING message_id, created_at, updated_at`
+
+	err := r.db.QueryRow(ctx, query,
+		message.Text, message.FromID, message.ChatID, message.AttachmentIDs(),
+		message.ReplyToID, message.ThreadID).Scan(
+		&message.ID,
+		&message.CreatedAt,
+		&message.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// GetChatMessages returns up to limit messages of the chat written before the
+// message with id before (or the latest ones when before is 0), oldest first.
+func (r *messageRepo) GetChatMessages(ctx context.Context, chatID int, before int, limit int) ([]models.Message, error) {
+	query := `
+		SELECT * FROM (
+			SELECT
+				message_id,
+				COALESCE(text, ''),
+				from_id,
+				chat_id,
+				COALESCE(reply_to_id, 0),
+				COALESCE(thread_id, 0),
+				COALESCE(attachment_ids, '{}'),
+				created_at,
+				updated_at
+			FROM
+				messages
+			WHERE
+				chat_id = $1 AND is_deleted = false AND ($2 = 0 OR message_id < $2)
+			ORDER BY
+				message_id DESC
+			LIMIT $3
+		) AS page
+		ORDER BY
+			message_id ASC`
+
+	return r.queryMessages(ctx, query, chatID, before, limit)
+}
+
+// GetChatMessagesAfter returns up to limit messages of the chat written after
+// the message with id after, oldest first.
+func (r *messageRepo) GetChatMessagesAfter(ctx context.Context, chatID int, after int, limit int) ([]models.Message, error) {
+	query := `
+		SELECT
+			message_id,
+			COALESCE(text, ''),
+			from_id,
+			chat_id,
+			COALESCE(reply_to_id, 0),
+			COALESCE(thread_id, 0),
+			COALESCE(attachment_ids, '{}'),
+			created_at,
+			updated_at
+		FROM
+			messages
+		WHERE
+			chat_id = $1 AND is_deleted = false AND message_id > $2
+		ORDER BY
+			message_id ASC
+		LIMIT $3`
+
+	return r.queryMessages(ctx, query, chatID, after, limit)
+}
+
+// GetThreadMessages returns up to limit replies in the thread of the message
+// with id rootID written after the message with id after, oldest first.
+func (r *messageRepo) GetThreadMessages(ctx context.Context, rootID int, after int, limit int) ([]models.Message, error) {
+	query := `
+		SELECT
+			message_id,
+			COALESCE(text, ''),
+			from_id,
+			chat_id,
+			COALESCE(reply_to_id, 0),
+			COALESCE(thread_id, 0),
+			COALESCE(attachment_ids, '{}'),
+			created_at,
+			updated_at
+		FROM
+			messages
+		WHERE
+			thread_id = $1 AND is_deleted = false AND message_id > $2
+		ORDER BY
+			message_id ASC
+		LIMIT $3`
+
+	return r.queryMessages(ctx, query, rootID, after, limit)
+}
+
+// queryMessages scans the messages selected by query along with their
+// attachments, reactions and thread summaries.
+func (r *messageRepo) queryMessages(ctx context.Context, query string, args ...any) ([]models.Message, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.Message
+	var attachmentIDs [][]int
+
+	for rows.Next() {
+		var message models.Message
+		var ids []int
+		err := rows.Scan(
+			&message.ID,
+			&message.Text,
+			&message.FromID,
+			&message.ChatID,
+			&message.ReplyToID,
+			&message.ThreadID,
+			&ids,
+			&message.CreatedAt,
+			&message.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+		attachmentIDs = append(attachmentIDs, ids)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err = r.fillAttachments(ctx, messages, attachmentIDs); err != nil {
+		return nil, err
+	}
+
+	if err = r.fillReactions(ctx, messages); err != nil {
+		return nil, err
+	}
+
+	if err = r.fillThreads(ctx, messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (r *messageRepo) GetByID(ctx context.Context, messageID int) (*models.Message, error) {
+	query := `
+		SELECT
+			message_id,
+			COALESCE(text, ''),
+			from_id,
+			chat_id,
+			COALESCE(reply_to_id, 0),
+			COALESCE(thread_id, 0),
+			is_deleted,
+			created_at,
+			updated_at
+		FROM
+			messages
+		WHERE
+			message_id = $1`
+
+	var message models.Message
+
+	err := r.db.QueryRow(ctx, query, messageID).Scan(
+		&message.ID,
+		&message.Text,
+		&message.FromID,
+		&message.ChatID,
+		&message.ReplyToID,
+		&message.ThreadID,
+		&message.IsDeleted,
+		&message.CreatedAt,
+		&message.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperror.ErrMessageNotFound
+		}
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// UpdateText replaces the text of the message and returns it as the history
+// shows it, with its attachments, reactions and thread summary.
+func (r *messageRepo) UpdateText(ctx context.Context, message *models.Message) (*models.Message, error) {
+	query := `
+		UPDATE 
+			messages
+		SET 
+			text = $2, 
+			updated_at = now()
+		WHERE 
+			message_id = $1 AND is_deleted = false
+		RETURNING
+			message_id,
+			COALESCE(text, ''),
+			from_id,
+			chat_id,
+			COALESCE(reply_to_id, 0),
+			COALESCE(thread_id, 0),
+			COALESCE(attachment_ids, '{}'),
+			created_at,
+			updated_at`
+
+	messages, err := r.queryMessages(ctx, query, message.ID, message.Text)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, apperror.ErrMessageNotFound
+	}
+
+	return &messages[0], nil
+}
+
+// Delete marks the message as deleted, the row itself is kept.
+func (r *messageRepo) Delete(ctx context.Context, message *models.Message) (*models.Message, error) {
+	query := `
+		UPDATE 
+			messages
+		SET 
+			is_deleted = true, 
+			updated_at = now()
+		WHERE 
+			message_id = $1 AND is_deleted = false
+		RETURNING updated_at`
+
+	err := r.db.QueryRow(ctx, query, message.ID).Scan(&message.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperror.ErrMessageNotFound
+		}
+		return nil, err
+	}
+	message.IsDeleted = true
+
+	return message, nil
+}
+
+// UpdateReadPosition moves the read position of the member forward to the
+// message and returns the resulting position. Ids of messages of other chats
+// leave the position as is.
+func (r *messageRepo) UpdateReadPosition(ctx context.Context, chatID int, userID int, messageID int) (int, error) {
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(context.Background())
+
+	query := `
+		UPDATE 
+			chat_members
+		SET 
+			last_read_message_id = GREATEST(
+				last_read_message_id,
+				(SELECT message_id FROM messages WHERE chat_id = $1 AND message_id = $3)
+			)
+		WHERE 
+			chat_id = $1 AND user_id = $2
+		RETURNING last_read_message_id`
+
+	var position int
+	err = tx.QueryRow(ctx, query, chatID, userID, messageID).Scan(&position)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, apperror.ErrChatMemberNotFound
+		}
+		return 0, err
+	}
+
+	queryMessages := `
+		UPDATE 
+			messages
+		SET 
+			is_read = true
+		WHERE 
+			chat_id = $1 AND from_id <> $2 AND message_id <= $3 AND is_read = false`
+
+	_, err = tx.Exec(ctx, queryMessages, chatID, userID, position)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	return position, nil
+}
+
+// CountReaders returns the number of members besides the author whose read
+// position has reached the message.
+func (r *messageRepo) CountReaders(ctx context.Context, chatID int, messageID int) (int, error) {
+	query := `
+		SELECT
+			COUNT(*)
+		FROM
+			chat_members AS cm
+		JOIN
+			messages AS m
+		ON
+			m.chat_id = cm.chat_id
+		WHERE
+			m.chat_id = $1 
+			AND m.message_id = $2 
+			AND cm.user_id <> m.from_id 
+			AND cm.last_read_message_id >= m.message_id`
+
+	var readers int
+	if err := r.db.QueryRow(ctx, query, chatID, messageID).Scan(&readers); err != nil {
+		return 0, err
+	}
+
+	return readers, nil
+}
+
+const fileColumns = `
+			file_id,
+			COALESCE(file_name, ''),
+			COALESCE(file_type, ''),
+			COALESCE(storage_path, ''),
+			created_at`
+
+// fillAttachments loads the files referenced by messages[i] through
+// attachmentIDs[i] with a single query.
+func (r *messageRepo) fillAttachments(ctx context.Context, messages []models.Message, attachmentIDs [][]int) error {
+	var ids []int
+	for _, messageIDs := range attachmentIDs {
+		ids = append(ids, messageIDs...)
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := `
+		SELECT` + fileColumns + `
+		FROM
+			files
+		WHERE
+			file_id = ANY($1)`
+
+	files, err := r.queryFiles(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		for _, id := range attachmentIDs[i] {
+			if file, ok := files[id]; ok {
+				file.MessageID = messages[i].ID
+				messages[i].Attachments = append(messages[i].Attachments, file)
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetAuthorFiles returns the files with the given ids uploaded by the user,
+// keyed by id. Ids of other users' files are left out.
+func (r *messageRepo) GetAuthorFiles(ctx context.Context, authorID int, fileIDs []int) (map[int]models.Attachment, error) {
+	query := `
+		SELECT` + fileColumns + `
+		FROM
+			files
+		WHERE
+			author_id = $1 AND file_id = ANY($2)`
+
+	return r.queryFiles(ctx, query, authorID, fileIDs)
+}
+
+func (r *messageRepo) queryFiles(ctx context.Context, query string, args ...any) (map[int]models.Attachment, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make(map[int]models.Attachment)
+
+	for rows.Next() {
+		var file models.Attachment
+		err := rows.Scan(
+			&file.ID,
+			&file.Filename,
+			&file.FileType,
+			&file.Url,
+			&file.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		files[file.ID] = file
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// fillReactions loads the reactions to the messages with a single query.
+func (r *messageRepo) fillReactions(ctx context.Context, messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]int, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+
+	reactions, err := r.GetReactions(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].ID]
+	}
+
+	return nil
+}
+
+// GetReactions returns the reactions to the messages aggregated per emoji,
+// in the order the emojis were first used.
+func (r *messageRepo) GetReactions(ctx context.Context, messageIDs []int) (map[int][]models.Reaction, error) {
+	query := `
+		SELECT
+			message_id,
+			emoji,
+			array_agg(user_id ORDER BY created_at, user_id)
+		FROM
+			reactions
+		WHERE
+			message_id = ANY($1)
+		GROUP BY
+			message_id, emoji
+		ORDER BY
+			message_id, min(created_at)`
+
+	rows, err := r.db.Query(ctx, query, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reactions := make(map[int][]models.Reaction)
+
+	for rows.Next() {
+		var messageID int
+		var reaction models.Reaction
+		if err := rows.Scan(&messageID, &reaction.Emoji, &reaction.UserIDs); err != nil {
+			return nil, err
+		}
+		reaction.Count = len(reaction.UserIDs)
+		reactions[messageID] = append(reactions[messageID], reaction)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reactions, nil
+}
+
+// AddReaction stores the reaction of the user and reports whether it is new.
+func (r *messageRepo) AddReaction(ctx context.Context, messageID int, userID int, emoji string) (bool, error) {
+	query := `
+		INSERT INTO
+			reactions(message_id, user_id, emoji)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`
+
+	tag, err := r.db.Exec(ctx, query, messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// RemoveReaction deletes the reaction of the user and reports whether there
+// was one.
+func (r *messageRepo) RemoveReaction(ctx context.Context, messageID int, userID int, emoji string) (bool, error) {
+	query := `
+		DELETE FROM
+			reactions
+		WHERE
+			message_id = $1 AND user_id = $2 AND emoji = $3`
+
+	tag, err := r.db.Exec(ctx, query, messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// fillThreads sets the summaries of the messages that have replies. Replies
+// can't be roots, so only the other messages are looked up.
+func (r *messageRepo) fillThreads(ctx context.Context, messages []models.Message) error {
+	ids := make([]int, 0, len(messages))
+	for _, message := range messages {
+		if message.ThreadID == 0 {
+			ids = append(ids, message.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	summaries, err := r.GetThreadSummaries(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Thread = summaries[messages[i].ID]
+	}
+
+	return nil
+}
+
+// GetThreadSummaries returns the summaries of the threads of the messages,
+// messages without replies are left out. The author of the root comes first
+// among the participants.
+func (r *messageRepo) GetThreadSummaries(ctx context.Context, rootIDs []int) (map[int]*models.ThreadSummary, error) {
+	query := `
+		SELECT
+			t.thread_id,
+			t.chat_id,
+			count(*),
+			max(t.message_id),
+			max(t.created_at),
+			root.from_id,
+			array_agg(DISTINCT t.from_id)
+		FROM
+			messages AS t
+		JOIN
+			messages AS root
+		ON
+			root.message_id = t.thread_id
+		WHERE
+			t.thread_id = ANY($1) AND t.is_deleted = false
+		GROUP BY
+			t.thread_id, t.chat_id, root.from_id`
+
+	rows, err := r.db.Query(ctx, query, rootIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := make(map[int]*models.ThreadSummary)
+
+	for rows.Next() {
+		var summary models.ThreadSummary
+		var rootFromID int
+		var replierIDs []int
+		err := rows.Scan(
+			&summary.RootID,
+			&summary.ChatID,
+			&summary.ReplyCount,
+			&summary.LastReplyID,
+			&summary.LastReplyAt,
+			&rootFromID,
+			&replierIDs,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		summary.ParticipantIDs = append(summary.ParticipantIDs, rootFromID)
+		for _, id := range replierIDs {
+			if id != rootFromID {
+				summary.ParticipantIDs = append(summary.ParticipantIDs, id)
+			}
+		}
+		summaries[summary.RootID] = &summary
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return summaries, nil
+}
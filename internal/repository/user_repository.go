@@ -308,6 +308,61 @@ func (r *userRepo) GetAll(ctx context.Context) ([]models.User, error) {
 	return users, nil
 }
 
+func (r *userRepo) GetByIDs(ctx context.Context, userIDs []int) ([]models.User, error) {
+	query := `
+		SELECT 
+			u.user_id, 
+			u.username,
+			u.firstname,
+			u.lastname,
+			u.patronymic,
+			u.email, 
+			u.role,
+			e.position,
+			u.created_at,
+			u.updated_at
+		FROM 
+			users as u
+		JOIN 
+			employees as e
+		ON
+			u.email = e.email
+		WHERE 
+			u.user_id = ANY($1)`
+
+	rows, err := r.db.Query(ctx, query, userIDs)
+	if err != nil {
+		return nil, apperror.ErrInternal
+	}
+	defer rows.Close()
+
+	var users []models.User
+
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.Name,
+			&user.Lastname,
+			&user.Patronymic,
+			&user.Email,
+			&user.Role,
+			&user.Tag,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		); err != nil {
+			return nil, apperror.ErrInternal
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, apperror.ErrInternal
+	}
+
+	return users, nil
+}
+
 func isDuplicateError(err error) bool {
 	duplicate := regexp.MustCompile(`\(SQLSTATE 23505\)$`)
 	return duplicate.MatchString(err.Error())
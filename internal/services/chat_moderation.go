@@ -0,0 +1,114 @@
+package services
+
+import (
+	"chatie/internal/apperror"
+	"chatie/internal/models"
+	"context"
+	"fmt"
+	"log"
+)
+
+// Moderate takes the action on the member of a group chat. Owners and admins
+// may moderate regular members, only owners may moderate admins and change
+// roles, and nobody may moderate the owner or themselves.
+func (c *chatService) Moderate(ctx context.Context, moderation models.Moderation) (*models.Moderation, error) {
+	if err := moderation.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", apperror.ErrModerationInvalid, err)
+	}
+
+	actor, err := c.getModerator(ctx, moderation.ChatID, moderation.ActorID)
+	if err != nil {
+		return nil, err
+	}
+	if moderation.UserID == moderation.ActorID {
+		return nil, apperror.ErrForbidden
+	}
+
+	member, err := c.repo.GetChatMemberByID(ctx, moderation.ChatID, moderation.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if member.Role == models.UserOwner {
+		return nil, apperror.ErrForbidden
+	}
+	if moderation.Action == models.ModerationKick && member.IsBanned {
+		// removing the member would lift the ban
+		return nil, fmt.Errorf("%w: banned members can't be kicked", apperror.ErrModerationInvalid)
+	}
+	if (member.Role == models.UserAdmin || moderation.ChangesRole()) && actor.Role != models.UserOwner {
+		return nil, apperror.ErrForbidden
+	}
+
+	if err := c.apply(ctx, &moderation); err != nil {
+		if err == apperror.ErrChatMemberNotFound {
+			return nil, err
+		}
+		log.Println("{moderate}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	if moderation.Action != models.ModerationKick {
+		member, err := c.repo.GetChatMemberByID(ctx, moderation.ChatID, moderation.UserID)
+		if err != nil {
+			log.Println("{moderate}", err)
+			return nil, apperror.ErrInternal
+		}
+		moderation.Member = member.Profile()
+	}
+
+	return &moderation, nil
+}
+
+func (c *chatService) apply(ctx context.Context, moderation *models.Moderation) error {
+	chatID, userID := moderation.ChatID, moderation.UserID
+
+	switch moderation.Action {
+	case models.ModerationKick:
+		return c.repo.RemoveMember(ctx, chatID, userID)
+	case models.ModerationBan:
+		return c.repo.BanMember(ctx, chatID, userID, moderation.Duration)
+	case models.ModerationUnban:
+		return c.repo.UnbanMember(ctx, chatID, userID)
+	case models.ModerationMute:
+		return c.repo.MuteMember(ctx, chatID, userID, moderation.Duration)
+	case models.ModerationUnmute:
+		return c.repo.MuteMember(ctx, chatID, userID, 0)
+	case models.ModerationPromote:
+		return c.repo.SetMemberRole(ctx, chatID, userID, models.UserAdmin)
+	case models.ModerationDemote:
+		return c.repo.SetMemberRole(ctx, chatID, userID, models.UserDefault)
+	}
+
+	return nil
+}
+
+// getModerator returns the member if they may moderate the chat.
+func (c *chatService) getModerator(ctx context.Context, chatID int, userID int) (*models.ChatUser, error) {
+	chat, err := c.repo.GetByID(ctx, chatID)
+	if err != nil {
+		if err == apperror.ErrChatNotFound {
+			return nil, err
+		}
+		log.Println("{get chat}", err)
+		return nil, apperror.ErrInternal
+	}
+	if chat.IsDeleted {
+		return nil, apperror.ErrChatDeleted
+	}
+	if chat.DirectKey != "" {
+		return nil, apperror.ErrForbidden
+	}
+
+	member, err := c.repo.GetChatMemberByID(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if member.IsBanned {
+		return nil, apperror.ErrChatMemberBanned
+	}
+	if member.Role != models.UserOwner && member.Role != models.UserAdmin {
+		return nil, apperror.ErrForbidden
+	}
+
+	return member, nil
+}
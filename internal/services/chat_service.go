@@ -1,9 +1,13 @@
 package services
 
 import (
+	"chatie/internal/apperror"
 	"chatie/internal/models"
 	"context"
 	"errors"
+	"fmt"
+	"log"
+	"time"
 )
 
 type ChatRepository interface {
@@ -15,21 +19,228 @@ type ChatRepository interface {
 	GetChatMemberByID(ctx context.Context, chatID int, userID int) (*models.ChatUser, error)
 	GetChatMembersByID(ctx context.Context, chatID int) ([]models.ChatUser, error)
 	GetAllChatsByUserID(ctx context.Context, userID int) ([]models.Chat, error)
+	GetChatPartnerIDs(ctx context.Context, userID int) ([]int, error)
+	GetOrCreateDirect(ctx context.Context, userID int, peerID int) (*models.Chat, error)
+
+	RemoveMember(ctx context.Context, chatID int, userID int) error
+	BanMember(ctx context.Context, chatID int, userID int, seconds int) error
+	UnbanMember(ctx context.Context, chatID int, userID int) error
+	MuteMember(ctx context.Context, chatID int, userID int, seconds int) error
+	SetMemberRole(ctx context.Context, chatID int, userID int, role string) error
+
+	TransferOwnership(ctx context.Context, chatID int, ownerID int, newOwnerID int) error
+	SoftDelete(ctx context.Context, chatID int) (*models.Chat, error)
+	Restore(ctx context.Context, chatID int, window time.Duration) (*models.Chat, error)
 }
 
 type chatService struct {
-	repo ChatRepository
+	repo          ChatRepository
+	userRepo      UserRepository
+	restoreWindow time.Duration // how long deleted chats can be restored
 }
 
+func NewChatService(repo ChatRepository, userRepo UserRepository, restoreWindow time.Duration) *chatService {
+	return &chatService{
+		repo:          repo,
+		userRepo:      userRepo,
+		restoreWindow: restoreWindow,
+	}
+}
+
+// AddChat creates a group chat owned by the user.
 func (c *chatService) AddChat(ctx context.Context, chat models.Chat, userID int) (*models.Chat, error) {
+	if err := chat.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", apperror.ErrChatInvalid, err)
+	}
+
+	chat.ID = 0
+	chat.DirectKey = ""
+	chat.GenerateLink()
+
 	createdChat, err := c.repo.Create(ctx, &chat, userID)
 	if err != nil {
-		return nil, err
+		log.Println("{add chat}", err)
+		return nil, apperror.ErrInternal
 	}
 
 	return createdChat, nil
 }
 
+// GetChat returns the chat if it isn't deleted. Private chats are only shown
+// to their members.
+func (c *chatService) GetChat(ctx context.Context, chatID int, userID int) (*models.Chat, error) {
+	chat, err := c.getChat(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	if chat.Private {
+		member, err := c.repo.GetChatMemberByID(ctx, chatID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if member.IsBanned {
+			return nil, apperror.ErrChatMemberBanned
+		}
+	}
+
+	return chat, nil
+}
+
+// UpdateChat changes the name, info or privacy of a group chat. Admins may
+// change the name and info, only the owner the privacy.
+func (c *chatService) UpdateChat(ctx context.Context, chatID int, userID int, update models.ChatUpdate) (*models.Chat, error) {
+	chat, err := c.getChat(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if chat.DirectKey != "" {
+		return nil, apperror.ErrForbidden
+	}
+
+	role, err := c.checkRole(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if role != models.UserOwner && role != models.UserAdmin {
+		return nil, apperror.ErrForbidden
+	}
+	if update.Private != nil && *update.Private != chat.Private && role != models.UserOwner {
+		return nil, apperror.ErrForbidden
+	}
+
+	update.Apply(chat)
+	if err := chat.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", apperror.ErrChatInvalid, err)
+	}
+
+	updatedChat, err := c.repo.Update(ctx, chat)
+	if err != nil {
+		if err == apperror.ErrChatNotFound {
+			return nil, err
+		}
+		log.Println("{update chat}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return updatedChat, nil
+}
+
+// TransferOwnership hands the chat over to another member, the owner stays on
+// as an admin.
+func (c *chatService) TransferOwnership(ctx context.Context, chatID int, ownerID int, newOwnerID int) (*models.Chat, error) {
+	chat, err := c.getChat(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if chat.DirectKey != "" || chat.OwnerID != ownerID || newOwnerID == ownerID {
+		return nil, apperror.ErrForbidden
+	}
+
+	member, err := c.repo.GetChatMemberByID(ctx, chatID, newOwnerID)
+	if err != nil {
+		return nil, err
+	}
+	if member.IsBanned {
+		return nil, apperror.ErrChatMemberBanned
+	}
+
+	if err := c.repo.TransferOwnership(ctx, chatID, ownerID, newOwnerID); err != nil {
+		if err == apperror.ErrChatNotFound || err == apperror.ErrChatMemberNotFound {
+			return nil, err
+		}
+		log.Println("{transfer ownership}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	chat.OwnerID = newOwnerID
+
+	return chat, nil
+}
+
+// DeleteChat soft deletes a group chat. The owner and global admins may
+// delete it.
+func (c *chatService) DeleteChat(ctx context.Context, chatID int, userID int) (*models.Chat, error) {
+	chat, err := c.getChat(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if chat.DirectKey != "" {
+		return nil, apperror.ErrForbidden
+	}
+	if chat.OwnerID != userID && !c.isGlobalAdmin(ctx, userID) {
+		return nil, apperror.ErrForbidden
+	}
+
+	deletedChat, err := c.repo.SoftDelete(ctx, chatID)
+	if err != nil {
+		if err == apperror.ErrChatNotFound {
+			return nil, err
+		}
+		log.Println("{delete chat}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return deletedChat, nil
+}
+
+// RestoreChat undoes the deletion of a chat within the restore window. Only
+// global admins may restore chats.
+func (c *chatService) RestoreChat(ctx context.Context, chatID int, userID int) (*models.Chat, error) {
+	if !c.isGlobalAdmin(ctx, userID) {
+		return nil, apperror.ErrForbidden
+	}
+
+	chat, err := c.repo.GetByID(ctx, chatID)
+	if err != nil {
+		if err == apperror.ErrChatNotFound {
+			return nil, err
+		}
+		log.Println("{restore chat}", err)
+		return nil, apperror.ErrInternal
+	}
+	if !chat.IsDeleted {
+		return chat, nil
+	}
+
+	restoredChat, err := c.repo.Restore(ctx, chatID, c.restoreWindow)
+	if err != nil {
+		if err == apperror.ErrChatNotFound {
+			return nil, apperror.ErrChatRestoreExpired
+		}
+		log.Println("{restore chat}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return restoredChat, nil
+}
+
+func (c *chatService) isGlobalAdmin(ctx context.Context, userID int) bool {
+	user, err := c.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return false
+	}
+
+	return user.Role == models.UserAdmin
+}
+
+func (c *chatService) getChat(ctx context.Context, chatID int) (*models.Chat, error) {
+	chat, err := c.repo.GetByID(ctx, chatID)
+	if err != nil {
+		if err == apperror.ErrChatNotFound {
+			return nil, err
+		}
+		log.Println("{get chat}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	if chat.IsDeleted {
+		return nil, apperror.ErrChatDeleted
+	}
+
+	return chat, nil
+}
+
 func (c *chatService) JoinChat(ctx context.Context, chatID int, userID int) error {
 	err := c.repo.AddUserToChat(ctx, chatID, userID, models.UserDefault)
 	if err != nil {
@@ -39,6 +250,30 @@ func (c *chatService) JoinChat(ctx context.Context, chatID int, userID int) erro
 	return nil
 }
 
+// OpenDirectChat returns the direct chat of the user with peerID, creating it
+// on first use. There is a single direct chat per pair of users.
+func (c *chatService) OpenDirectChat(ctx context.Context, userID int, peerID int) (*models.Chat, error) {
+	if peerID == userID {
+		return nil, apperror.ErrForbidden
+	}
+
+	if _, err := c.userRepo.GetByID(ctx, peerID); err != nil {
+		return nil, err
+	}
+
+	chat, err := c.repo.GetOrCreateDirect(ctx, userID, peerID)
+	if err != nil {
+		log.Println("{open direct chat}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	if chat.IsDeleted {
+		return nil, apperror.ErrChatDeleted
+	}
+
+	return chat, nil
+}
+
 func (c *chatService) IsOwner(ctx context.Context, chatID int, userID int) bool {
 	chat, err := c.repo.GetByID(ctx, chatID)
 	if err != nil {
@@ -54,6 +289,10 @@ func (c *chatService) GetAllUserChats(ctx context.Context, userID int) ([]models
 		return nil, err
 	}
 
+	if chats == nil {
+		chats = []models.Chat{}
+	}
+
 	return chats, nil
 }
 
@@ -62,6 +301,9 @@ func (c *chatService) checkRole(ctx context.Context, chatID int, userID int) (st
 	if err != nil {
 		return "", err
 	}
+	if user.IsBanned {
+		return "", apperror.ErrChatMemberBanned
+	}
 
 	if user.Role == "" {
 		return "", errors.New("no roles")
@@ -0,0 +1,283 @@
+package services
+
+import (
+	"chatie/internal/apperror"
+	"chatie/internal/models"
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+type InviteRepository interface {
+	Create(ctx context.Context, invite *models.Invite) (*models.Invite, error)
+	GetByToken(ctx context.Context, token string) (*models.Invite, error)
+	GetByChatID(ctx context.Context, chatID int) ([]models.Invite, error)
+	Revoke(ctx context.Context, chatID int, inviteID int) (*models.Invite, error)
+	Use(ctx context.Context, inviteID int) error
+	CreateJoinRequest(ctx context.Context, request *models.JoinRequest) (bool, error)
+	GetJoinRequests(ctx context.Context, chatID int) ([]models.JoinRequest, error)
+	DeleteJoinRequest(ctx context.Context, chatID int, userID int) error
+}
+
+type inviteService struct {
+	inviteRepo InviteRepository
+	chatRepo   ChatRepository
+}
+
+func NewInviteService(inviteRepo InviteRepository, chatRepo ChatRepository) *inviteService {
+	return &inviteService{
+		inviteRepo: inviteRepo,
+		chatRepo:   chatRepo,
+	}
+}
+
+// CreateInvite creates an invite link to a group chat. Only admins and the
+// owner may create invites.
+func (s *inviteService) CreateInvite(ctx context.Context, chatID int, userID int, invite models.Invite) (*models.Invite, error) {
+	chat, err := s.checkAdmin(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if chat.DirectKey != "" {
+		return nil, apperror.ErrForbidden
+	}
+
+	if err := invite.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", apperror.ErrInviteInvalid, err)
+	}
+
+	invite.ID = 0
+	invite.ChatID = chatID
+	invite.CreatorID = userID
+	invite.Uses = 0
+	invite.IsRevoked = false
+	invite.GenerateToken()
+
+	createdInvite, err := s.inviteRepo.Create(ctx, &invite)
+	if err != nil {
+		log.Println("{create invite}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return createdInvite, nil
+}
+
+// GetInvites returns the invites of the chat, revoked and used up ones
+// included.
+func (s *inviteService) GetInvites(ctx context.Context, chatID int, userID int) ([]models.Invite, error) {
+	if _, err := s.checkAdmin(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	invites, err := s.inviteRepo.GetByChatID(ctx, chatID)
+	if err != nil {
+		log.Println("{get invites}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return invites, nil
+}
+
+// RevokeInvite stops the invite from being used, pending join requests made
+// with it are kept.
+func (s *inviteService) RevokeInvite(ctx context.Context, chatID int, userID int, inviteID int) (*models.Invite, error) {
+	if _, err := s.checkAdmin(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	invite, err := s.inviteRepo.Revoke(ctx, chatID, inviteID)
+	if err != nil {
+		if err == apperror.ErrInviteNotFound {
+			return nil, err
+		}
+		log.Println("{revoke invite}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return invite, nil
+}
+
+// JoinByToken adds the user to the chat of the invite, or files a join
+// request if the invite requires approval. A use of the invite is counted
+// for every new member or request, users that are already members don't use
+// it up.
+func (s *inviteService) JoinByToken(ctx context.Context, token string, userID int) (*models.JoinResult, error) {
+	invite, err := s.inviteRepo.GetByToken(ctx, token)
+	if err != nil {
+		if err == apperror.ErrInviteNotFound {
+			return nil, err
+		}
+		log.Println("{join by token}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	chat, err := s.getChat(ctx, invite.ChatID)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := s.chatRepo.GetChatMemberByID(ctx, chat.ID, userID)
+	if err == nil {
+		if member.IsBanned {
+			return nil, apperror.ErrChatMemberBanned
+		}
+		return &models.JoinResult{Chat: chat, Member: member, AlreadyMember: true}, nil
+	}
+	if err != apperror.ErrChatMemberNotFound {
+		log.Println("{join by token}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	if !invite.IsUsable(time.Now()) {
+		return nil, apperror.ErrInviteExpired
+	}
+
+	if invite.RequiresApproval {
+		return s.requestToJoin(ctx, chat, invite, userID)
+	}
+
+	if err := s.inviteRepo.Use(ctx, invite.ID); err != nil {
+		if err == apperror.ErrInviteExpired {
+			return nil, err
+		}
+		log.Println("{join by token}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	member, err = s.addMember(ctx, chat.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.JoinResult{Chat: chat, Member: member}, nil
+}
+
+func (s *inviteService) requestToJoin(ctx context.Context, chat *models.Chat, invite *models.Invite, userID int) (*models.JoinResult, error) {
+	request := models.JoinRequest{
+		ChatID:   chat.ID,
+		UserID:   userID,
+		InviteID: invite.ID,
+	}
+
+	created, err := s.inviteRepo.CreateJoinRequest(ctx, &request)
+	if err != nil {
+		log.Println("{request to join}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	if created {
+		if err := s.inviteRepo.Use(ctx, invite.ID); err != nil {
+			s.inviteRepo.DeleteJoinRequest(ctx, chat.ID, userID)
+			if err == apperror.ErrInviteExpired {
+				return nil, err
+			}
+			log.Println("{request to join}", err)
+			return nil, apperror.ErrInternal
+		}
+	}
+
+	return &models.JoinResult{Chat: chat, Pending: true}, nil
+}
+
+// GetJoinRequests returns the pending requests to join the chat.
+func (s *inviteService) GetJoinRequests(ctx context.Context, chatID int, userID int) ([]models.JoinRequest, error) {
+	if _, err := s.checkAdmin(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	requests, err := s.inviteRepo.GetJoinRequests(ctx, chatID)
+	if err != nil {
+		log.Println("{get join requests}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return requests, nil
+}
+
+// ApproveJoinRequest adds the user that asked to join the chat as a member.
+func (s *inviteService) ApproveJoinRequest(ctx context.Context, chatID int, adminID int, userID int) (*models.ChatUser, error) {
+	if _, err := s.checkAdmin(ctx, chatID, adminID); err != nil {
+		return nil, err
+	}
+
+	if err := s.deleteJoinRequest(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	return s.addMember(ctx, chatID, userID)
+}
+
+// RejectJoinRequest drops the request of the user to join the chat.
+func (s *inviteService) RejectJoinRequest(ctx context.Context, chatID int, adminID int, userID int) error {
+	if _, err := s.checkAdmin(ctx, chatID, adminID); err != nil {
+		return err
+	}
+
+	return s.deleteJoinRequest(ctx, chatID, userID)
+}
+
+func (s *inviteService) deleteJoinRequest(ctx context.Context, chatID int, userID int) error {
+	if err := s.inviteRepo.DeleteJoinRequest(ctx, chatID, userID); err != nil {
+		if err == apperror.ErrJoinRequestNotFound {
+			return err
+		}
+		log.Println("{delete join request}", err)
+		return apperror.ErrInternal
+	}
+
+	return nil
+}
+
+func (s *inviteService) addMember(ctx context.Context, chatID int, userID int) (*models.ChatUser, error) {
+	if err := s.chatRepo.AddUserToChat(ctx, chatID, userID, models.UserDefault); err != nil {
+		log.Println("{add member}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	member, err := s.chatRepo.GetChatMemberByID(ctx, chatID, userID)
+	if err != nil {
+		log.Println("{add member}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return member, nil
+}
+
+// checkAdmin returns the chat if the user is one of its admins or its owner.
+func (s *inviteService) checkAdmin(ctx context.Context, chatID int, userID int) (*models.Chat, error) {
+	chat, err := s.getChat(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := s.chatRepo.GetChatMemberByID(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if member.IsBanned {
+		return nil, apperror.ErrChatMemberBanned
+	}
+	if member.Role != models.UserOwner && member.Role != models.UserAdmin {
+		return nil, apperror.ErrForbidden
+	}
+
+	return chat, nil
+}
+
+func (s *inviteService) getChat(ctx context.Context, chatID int) (*models.Chat, error) {
+	chat, err := s.chatRepo.GetByID(ctx, chatID)
+	if err != nil {
+		if err == apperror.ErrChatNotFound {
+			return nil, err
+		}
+		log.Println("{get chat}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	if chat.IsDeleted {
+		return nil, apperror.ErrChatDeleted
+	}
+
+	return chat, nil
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"chatie/internal/apperror"
+	"chatie/internal/models"
+	"context"
+	"log"
+)
+
+const maxUnseenMentions = 100
+
+type MentionRepository interface {
+	Create(ctx context.Context, mentions []models.Mention) ([]models.Mention, error)
+	GetUnseen(ctx context.Context, userID int, limit int) ([]models.Mention, error)
+	MarkSeen(ctx context.Context, userID int, mentionIDs []int) (int, error)
+}
+
+type mentionService struct {
+	mentionRepo MentionRepository
+	userRepo    UserRepository
+	chatRepo    ChatRepository
+}
+
+func NewMentionService(
+	mentionRepo MentionRepository,
+	userRepo UserRepository,
+	chatRepo ChatRepository,
+) *mentionService {
+	return &mentionService{
+		mentionRepo: mentionRepo,
+		userRepo:    userRepo,
+		chatRepo:    chatRepo,
+	}
+}
+
+// CreateMentions stores the mentions of a saved message. Unknown usernames,
+// the author and users that aren't members of the chat are skipped, so a
+// mention never reveals a message to someone who can't read the chat.
+func (s *mentionService) CreateMentions(ctx context.Context, message *models.Message) ([]models.Mention, error) {
+	var mentions []models.Mention
+
+	for _, username := range message.Mentions() {
+		user, err := s.userRepo.GetByUsername(ctx, username)
+		if err != nil {
+			if err != apperror.ErrUserNotFound {
+				log.Println("{mentions}", err)
+			}
+			continue
+		}
+		if user.ID == message.FromID {
+			continue
+		}
+
+		member, err := s.chatRepo.GetChatMemberByID(ctx, message.ChatID, user.ID)
+		if err != nil || member.IsBanned {
+			continue
+		}
+
+		mentions = append(mentions, models.Mention{
+			MessageID:       message.ID,
+			ChatID:          message.ChatID,
+			FromID:          message.FromID,
+			MentionedUserID: user.ID,
+			Message:         message,
+		})
+	}
+
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+
+	mentions, err := s.mentionRepo.Create(ctx, mentions)
+	if err != nil {
+		log.Println("{mentions}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return mentions, nil
+}
+
+// GetUnseenMentions returns the latest mentions of the user that haven't been
+// marked as seen.
+func (s *mentionService) GetUnseenMentions(ctx context.Context, userID int) ([]models.Mention, error) {
+	mentions, err := s.mentionRepo.GetUnseen(ctx, userID, maxUnseenMentions)
+	if err != nil {
+		log.Println("{unseen mentions}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return mentions, nil
+}
+
+// MarkMentionsSeen marks the mentions of the user as seen, ids of other users'
+// mentions are ignored.
+func (s *mentionService) MarkMentionsSeen(ctx context.Context, userID int, mentionIDs []int) (int, error) {
+	if len(mentionIDs) == 0 {
+		return 0, nil
+	}
+
+	updated, err := s.mentionRepo.MarkSeen(ctx, userID, mentionIDs)
+	if err != nil {
+		log.Println("{mark mentions seen}", err)
+		return 0, apperror.ErrInternal
+	}
+
+	return updated, nil
+}
@@ -1 +1,474 @@
 package services
+
+import (
+	"chatie/internal/apperror"
+	"chatie/internal/models"
+	"context"
+	"fmt"
+	"log"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 100
+)
+
+type MessageRepository interface {
+	Create(ctx context.Context, message *models.Message) (*models.Message, error)
+	GetAuthorFiles(ctx context.Context, authorID int, fileIDs []int) (map[int]models.Attachment, error)
+	GetChatMessages(ctx context.Context, chatID int, before int, limit int) ([]models.Message, error)
+	GetChatMessagesAfter(ctx context.Context, chatID int, after int, limit int) ([]models.Message, error)
+	UpdateReadPosition(ctx context.Context, chatID int, userID int, messageID int) (int, error)
+	CountReaders(ctx context.Context, chatID int, messageID int) (int, error)
+	GetByID(ctx context.Context, messageID int) (*models.Message, error)
+	UpdateText(ctx context.Context, message *models.Message) (*models.Message, error)
+	Delete(ctx context.Context, message *models.Message) (*models.Message, error)
+	AddReaction(ctx context.Context, messageID int, userID int, emoji string) (bool, error)
+	RemoveReaction(ctx context.Context, messageID int, userID int, emoji string) (bool, error)
+	GetReactions(ctx context.Context, messageIDs []int) (map[int][]models.Reaction, error)
+	GetThreadMessages(ctx context.Context, rootID int, after int, limit int) ([]models.Message, error)
+	GetThreadSummaries(ctx context.Context, rootIDs []int) (map[int]*models.ThreadSummary, error)
+}
+
+type messageService struct {
+	messageRepo MessageRepository
+	chatRepo    ChatRepository
+}
+
+func NewMessageService(messageRepo MessageRepository, chatRepo ChatRepository) *messageService {
+	return &messageService{
+		messageRepo: messageRepo,
+		chatRepo:    chatRepo,
+	}
+}
+
+// SaveMessage stores the message written by userID to chatID and stamps it
+// with the server-assigned id, author, chat and creation time. A reply joins
+// the thread of the message it replies to. Banned and muted members can't
+// send messages.
+func (m *messageService) SaveMessage(ctx context.Context, message *models.Message, chatID int, userID int) (*models.Message, error) {
+	if err := message.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", apperror.ErrMessageInvalid, err)
+	}
+
+	member, err := m.getMember(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if member.IsMuted() {
+		return nil, apperror.ErrChatMemberMuted
+	}
+
+	message.ID = 0
+	message.FromID = userID
+	message.ChatID = chatID
+	message.ChannelID = ""
+	message.ThreadID = 0
+	message.Thread = nil
+	message.Reactions = nil
+
+	if err := m.loadAttachments(ctx, message); err != nil {
+		return nil, err
+	}
+
+	if message.ReplyToID != 0 {
+		repliedMessage, err := m.getChatMessage(ctx, chatID, message.ReplyToID)
+		if err != nil {
+			if err == apperror.ErrMessageNotFound {
+				return nil, fmt.Errorf("%w: replied message not found", apperror.ErrMessageInvalid)
+			}
+			return nil, err
+		}
+
+		message.ThreadID = repliedMessage.ThreadID
+		if message.ThreadID == 0 {
+			message.ThreadID = repliedMessage.ID
+		}
+	}
+
+	savedMessage, err := m.messageRepo.Create(ctx, message)
+	if err != nil {
+		log.Println("{save message}", err)
+		return nil, apperror.ErrMessageNotSaved
+	}
+
+	return savedMessage, nil
+}
+
+// GetHistory returns a page of chat messages older than the message with id
+// before, oldest first. Only members of the chat may read its history, and
+// not once the chat is deleted.
+func (m *messageService) GetHistory(ctx context.Context, chatID int, userID int, before int, limit int) ([]models.Message, error) {
+	if _, err := m.getMember(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+	if before < 0 {
+		before = 0
+	}
+
+	messages, err := m.messageRepo.GetChatMessages(ctx, chatID, before, limit)
+	if err != nil {
+		log.Println("{get history}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	if messages == nil {
+		messages = []models.Message{}
+	}
+
+	return messages, nil
+}
+
+// GetThread returns the message with id rootID and a page of its replies
+// written after the message with id after, oldest first. Only members of the
+// chat may read the thread.
+func (m *messageService) GetThread(ctx context.Context, chatID int, userID int, rootID int, after int, limit int) (*models.Thread, error) {
+	if _, err := m.getMember(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	root, err := m.getChatMessage(ctx, chatID, rootID)
+	if err != nil {
+		return nil, err
+	}
+	if root.ThreadID != 0 {
+		root, err = m.getChatMessage(ctx, chatID, root.ThreadID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+	if after < 0 {
+		after = 0
+	}
+
+	replies, err := m.messageRepo.GetThreadMessages(ctx, root.ID, after, limit)
+	if err != nil {
+		log.Println("{get thread}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	root.Thread, err = m.GetThreadSummary(ctx, chatID, root.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if replies == nil {
+		replies = []models.Message{}
+	}
+
+	return &models.Thread{
+		Root:    root,
+		Replies: replies,
+	}, nil
+}
+
+// GetThreadSummary returns the summary of the thread of the message with id
+// rootID. The caller is responsible for checking the membership.
+func (m *messageService) GetThreadSummary(ctx context.Context, chatID int, rootID int) (*models.ThreadSummary, error) {
+	root, err := m.getChatMessage(ctx, chatID, rootID)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries, err := m.messageRepo.GetThreadSummaries(ctx, []int{rootID})
+	if err != nil {
+		log.Println("{get thread summary}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	summary, ok := summaries[rootID]
+	if !ok {
+		return &models.ThreadSummary{
+			ChatID:         chatID,
+			RootID:         rootID,
+			ParticipantIDs: []int{root.FromID},
+		}, nil
+	}
+
+	return summary, nil
+}
+
+// GetMessagesAfter returns up to limit messages of the chat written after the
+// message with id after, oldest first. The caller is responsible for checking
+// the membership.
+func (m *messageService) GetMessagesAfter(ctx context.Context, chatID int, after int, limit int) ([]models.Message, error) {
+	messages, err := m.messageRepo.GetChatMessagesAfter(ctx, chatID, after, limit)
+	if err != nil {
+		log.Println("{get messages after}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return messages, nil
+}
+
+// MarkRead moves the user's read position in the chat up to the message and
+// returns the resulting receipt. The message must belong to the chat, deleted
+// ones included, and banned members can't move their position.
+func (m *messageService) MarkRead(ctx context.Context, chatID int, userID int, messageID int) (*models.ReadReceipt, error) {
+	if messageID <= 0 {
+		return nil, apperror.ErrMessageNotFound
+	}
+
+	if _, err := m.getMember(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	message, err := m.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		if err == apperror.ErrMessageNotFound {
+			return nil, err
+		}
+		log.Println("{mark read}", err)
+		return nil, apperror.ErrInternal
+	}
+	if message.ChatID != chatID {
+		return nil, apperror.ErrMessageNotFound
+	}
+
+	position, err := m.messageRepo.UpdateReadPosition(ctx, chatID, userID, messageID)
+	if err != nil {
+		if err == apperror.ErrChatMemberNotFound {
+			return nil, err
+		}
+		log.Println("{mark read}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	readBy, err := m.messageRepo.CountReaders(ctx, chatID, position)
+	if err != nil {
+		log.Println("{mark read}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return &models.ReadReceipt{
+		ChatID:    chatID,
+		UserID:    userID,
+		MessageID: position,
+		ReadBy:    readBy,
+	}, nil
+}
+
+// EditMessage replaces the text of a message. Only the author may edit it,
+// and not while muted.
+func (m *messageService) EditMessage(ctx context.Context, chatID int, userID int, messageID int, text string) (*models.Message, error) {
+	message, err := m.getChatMessage(ctx, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if message.FromID != userID {
+		return nil, apperror.ErrForbidden
+	}
+	member, err := m.getMember(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if member.IsMuted() {
+		return nil, apperror.ErrChatMemberMuted
+	}
+
+	message.Text = text
+	if err := message.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", apperror.ErrMessageInvalid, err)
+	}
+
+	editedMessage, err := m.messageRepo.UpdateText(ctx, message)
+	if err != nil {
+		if err == apperror.ErrMessageNotFound {
+			return nil, err
+		}
+		log.Println("{edit message}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return editedMessage, nil
+}
+
+// DeleteMessage deletes a message and returns its tombstone. Authors may
+// delete their own messages, chat admins and owners anyone's.
+func (m *messageService) DeleteMessage(ctx context.Context, chatID int, userID int, messageID int) (*models.Message, error) {
+	message, err := m.getChatMessage(ctx, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := m.getMember(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	isModerator := member.Role == models.UserAdmin || member.Role == models.UserOwner
+	if message.FromID != userID && !isModerator {
+		return nil, apperror.ErrForbidden
+	}
+
+	deletedMessage, err := m.messageRepo.Delete(ctx, message)
+	if err != nil {
+		if err == apperror.ErrMessageNotFound {
+			return nil, err
+		}
+		log.Println("{delete message}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return deletedMessage.Tombstone(), nil
+}
+
+// AddReaction adds the emoji reaction of a member to a message and returns
+// the reactions to the message.
+func (m *messageService) AddReaction(ctx context.Context, chatID int, userID int, messageID int, emoji string) (*models.ReactionsUpdate, error) {
+	if err := models.ValidateEmoji(emoji); err != nil {
+		return nil, fmt.Errorf("%w: %s", apperror.ErrReactionInvalid, err)
+	}
+
+	if err := m.checkCanReact(ctx, chatID, userID, messageID); err != nil {
+		return nil, err
+	}
+
+	if _, err := m.messageRepo.AddReaction(ctx, messageID, userID, emoji); err != nil {
+		log.Println("{add reaction}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return m.getReactionsUpdate(ctx, chatID, userID, messageID, emoji, true)
+}
+
+// RemoveReaction removes the emoji reaction of a member from a message and
+// returns the reactions to the message. Removing a missing reaction is not
+// an error.
+func (m *messageService) RemoveReaction(ctx context.Context, chatID int, userID int, messageID int, emoji string) (*models.ReactionsUpdate, error) {
+	if err := models.ValidateEmoji(emoji); err != nil {
+		return nil, fmt.Errorf("%w: %s", apperror.ErrReactionInvalid, err)
+	}
+
+	if err := m.checkCanReact(ctx, chatID, userID, messageID); err != nil {
+		return nil, err
+	}
+
+	if _, err := m.messageRepo.RemoveReaction(ctx, messageID, userID, emoji); err != nil {
+		log.Println("{remove reaction}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return m.getReactionsUpdate(ctx, chatID, userID, messageID, emoji, false)
+}
+
+// checkCanReact makes sure the message is in the chat and the user is a
+// member that is neither banned from it nor muted.
+func (m *messageService) checkCanReact(ctx context.Context, chatID int, userID int, messageID int) error {
+	if _, err := m.getChatMessage(ctx, chatID, messageID); err != nil {
+		return err
+	}
+
+	member, err := m.getMember(ctx, chatID, userID)
+	if err != nil {
+		return err
+	}
+	if member.IsMuted() {
+		return apperror.ErrChatMemberMuted
+	}
+
+	return nil
+}
+
+func (m *messageService) getReactionsUpdate(ctx context.Context, chatID int, userID int, messageID int, emoji string, added bool) (*models.ReactionsUpdate, error) {
+	reactions, err := m.messageRepo.GetReactions(ctx, []int{messageID})
+	if err != nil {
+		log.Println("{get reactions}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	return &models.ReactionsUpdate{
+		ChatID:    chatID,
+		MessageID: messageID,
+		UserID:    userID,
+		Emoji:     emoji,
+		Added:     added,
+		Reactions: reactions[messageID],
+	}, nil
+}
+
+// loadAttachments replaces the attachments sent with the message by the
+// stored files they refer to. Only files uploaded by the author may be
+// attached.
+func (m *messageService) loadAttachments(ctx context.Context, message *models.Message) error {
+	if len(message.Attachments) == 0 {
+		return nil
+	}
+
+	files, err := m.messageRepo.GetAuthorFiles(ctx, message.FromID, message.AttachmentIDs())
+	if err != nil {
+		log.Println("{load attachments}", err)
+		return apperror.ErrInternal
+	}
+
+	attachments := make([]models.Attachment, 0, len(message.Attachments))
+	for _, id := range message.AttachmentIDs() {
+		file, ok := files[id]
+		if !ok {
+			return fmt.Errorf("%w: attachment %d not found", apperror.ErrMessageInvalid, id)
+		}
+		attachments = append(attachments, file)
+	}
+	message.Attachments = attachments
+
+	return nil
+}
+
+// getMember returns the membership of the user in a chat that hasn't been
+// deleted. Banned members are refused.
+func (m *messageService) getMember(ctx context.Context, chatID int, userID int) (*models.ChatUser, error) {
+	chat, err := m.chatRepo.GetByID(ctx, chatID)
+	if err != nil {
+		if err == apperror.ErrChatNotFound {
+			return nil, err
+		}
+		log.Println("{get member}", err)
+		return nil, apperror.ErrInternal
+	}
+	if chat.IsDeleted {
+		return nil, apperror.ErrChatDeleted
+	}
+
+	member, err := m.chatRepo.GetChatMemberByID(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if member.IsBanned {
+		return nil, apperror.ErrChatMemberBanned
+	}
+
+	return member, nil
+}
+
+// getChatMessage returns a message of the chat that hasn't been deleted.
+func (m *messageService) getChatMessage(ctx context.Context, chatID int, messageID int) (*models.Message, error) {
+	message, err := m.messageRepo.GetByID(ctx, messageID)
+	if err != nil {
+		if err == apperror.ErrMessageNotFound {
+			return nil, err
+		}
+		log.Println("{get message}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	if message.ChatID != chatID || message.IsDeleted {
+		return nil, apperror.ErrMessageNotFound
+	}
+
+	return message, nil
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"chatie/internal/apperror"
+	"chatie/internal/models"
+	"chatie/internal/presence"
+	"context"
+	"log"
+	"time"
+)
+
+type PresenceStore interface {
+	Touch(ctx context.Context, userID int, connID string, status string, ttl time.Duration) error
+	TouchAll(ctx context.Context, beats []presence.Heartbeat, ttl time.Duration) error
+	Remove(ctx context.Context, userID int, connID string) error
+	Status(ctx context.Context, userIDs []int) (map[int]string, error)
+	Expired(ctx context.Context) ([]int, error)
+}
+
+type presenceService struct {
+	store    PresenceStore
+	chatRepo ChatRepository
+	userRepo UserRepository
+	ttl      time.Duration
+}
+
+func NewPresenceService(
+	store PresenceStore,
+	chatRepo ChatRepository,
+	userRepo UserRepository,
+	ttl time.Duration,
+) *presenceService {
+	return &presenceService{
+		store:    store,
+		chatRepo: chatRepo,
+		userRepo: userRepo,
+		ttl:      ttl,
+	}
+}
+
+// TTL is the time a connection stays alive without a heartbeat.
+func (p *presenceService) TTL() time.Duration {
+	return p.ttl
+}
+
+// SetStatus stores the status of one connection of the user and returns the
+// resulting status of the user and whether it has changed.
+func (p *presenceService) SetStatus(ctx context.Context, userID int, connID string, status string) (string, bool, error) {
+	if status != presence.StatusOnline && status != presence.StatusAway {
+		return "", false, apperror.ErrPresenceInvalid
+	}
+
+	before, err := p.status(ctx, userID)
+	if err != nil {
+		return "", false, err
+	}
+
+	if err := p.store.Touch(ctx, userID, connID, status, p.ttl); err != nil {
+		return "", false, err
+	}
+
+	after, err := p.status(ctx, userID)
+	if err != nil {
+		return "", false, err
+	}
+
+	return after, before != after, nil
+}
+
+// Disconnect forgets the connection and returns the resulting status of the
+// user and whether it has changed.
+func (p *presenceService) Disconnect(ctx context.Context, userID int, connID string) (string, bool, error) {
+	before, err := p.status(ctx, userID)
+	if err != nil {
+		return "", false, err
+	}
+
+	if err := p.store.Remove(ctx, userID, connID); err != nil {
+		return "", false, err
+	}
+
+	after, err := p.status(ctx, userID)
+	if err != nil {
+		return "", false, err
+	}
+
+	return after, before != after, nil
+}
+
+// Heartbeat extends the ttl of the live connections.
+func (p *presenceService) Heartbeat(ctx context.Context, beats []presence.Heartbeat) error {
+	if len(beats) == 0 {
+		return nil
+	}
+
+	return p.store.TouchAll(ctx, beats, p.ttl)
+}
+
+// ExpiredUsers returns the users that went offline because their
+// connections stopped sending heartbeats.
+func (p *presenceService) ExpiredUsers(ctx context.Context) ([]int, error) {
+	return p.store.Expired(ctx)
+}
+
+// GetWatchers returns the users that should be told about presence changes
+// of the given user, that is everyone who shares a chat with them.
+func (p *presenceService) GetWatchers(ctx context.Context, userID int) ([]int, error) {
+	return p.chatRepo.GetChatPartnerIDs(ctx, userID)
+}
+
+// GetOnlineUsers returns the users sharing a chat with the given user that
+// are online or away.
+func (p *presenceService) GetOnlineUsers(ctx context.Context, userID int) ([]models.User, error) {
+	partnerIDs, err := p.chatRepo.GetChatPartnerIDs(ctx, userID)
+	if err != nil {
+		log.Println("{online users}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	statuses, err := p.store.Status(ctx, partnerIDs)
+	if err != nil {
+		log.Println("{online users}", err)
+		return nil, apperror.ErrInternal
+	}
+
+	var onlineIDs []int
+	for partnerID, status := range statuses {
+		if status != presence.StatusOffline {
+			onlineIDs = append(onlineIDs, partnerID)
+		}
+	}
+
+	users := []models.User{}
+	if len(onlineIDs) == 0 {
+		return users, nil
+	}
+
+	partners, err := p.userRepo.GetByIDs(ctx, onlineIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, partner := range partners {
+		user := partner.Profile()
+		user.Presence = statuses[partner.ID]
+		user.IsOnline = user.Presence == presence.StatusOnline
+		users = append(users, *user)
+	}
+
+	return users, nil
+}
+
+func (p *presenceService) status(ctx context.Context, userID int) (string, error) {
+	statuses, err := p.store.Status(ctx, []int{userID})
+	if err != nil {
+		return "", err
+	}
+
+	return statuses[userID], nil
+}
@@ -12,6 +12,7 @@ type UserRepository interface {
 	GetByUsername(ctx context.Context, email string) (*models.User, error)
 	Delete(ctx context.Context, user *models.User) error
 	GetByID(ctx context.Context, userID int) (*models.User, error)
+	GetByIDs(ctx context.Context, userIDs []int) ([]models.User, error)
 	GetAll(ctx context.Context) ([]models.User, error)
 	Update(ctx context.Context, user *models.User) (*models.User, error)
 }
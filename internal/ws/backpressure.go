@@ -0,0 +1,200 @@
+package ws
+
+import (
+	"log"
+	"sort"
+	"sync/atomic"
+)
+
+// What happens to a frame sent to a connection whose queue is full
+const (
+	DropOldest     = "drop-oldest" // drop the oldest queued frame to make room
+	DropNewest     = "drop-newest" // drop the frame being sent
+	DisconnectSlow = "disconnect"  // close the connection
+)
+
+const slowConsumerReason = "slow consumer"
+
+// Max number of connections listed in the periodic queue report
+const maxReportedQueues = 10
+
+// QueueStats describes the send queue of a connection.
+type QueueStats struct {
+	ConnID    string `json:"connID"`
+	UserID    int    `json:"userID"`
+	Len       int    `json:"len"`
+	Cap       int    `json:"cap"`
+	HighWater int    `json:"highWater"` // longest the queue has been
+	Enqueued  uint64 `json:"enqueued"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+type queueMetrics struct {
+	enqueued  atomic.Uint64
+	dropped   atomic.Uint64
+	highWater atomic.Int64
+}
+
+func (m *queueMetrics) queued(length int) {
+	m.enqueued.Add(1)
+
+	for {
+		highWater := m.highWater.Load()
+		if int64(length) <= highWater || m.highWater.CompareAndSwap(highWater, int64(length)) {
+			return
+		}
+	}
+}
+
+// enqueue queues the frame for the connection. It never blocks: when the
+// queue is full the frame is handled according to the slow consumer policy,
+// so a stalled reader can't hold up the chats and users sending to it.
+func (client *Client) enqueue(message *WebsocketMessage) {
+	client.sendLock.RLock()
+	defer client.sendLock.RUnlock()
+
+	if client.closed {
+		return
+	}
+
+	select {
+	case client.send <- message:
+		client.metrics.queued(len(client.send))
+		return
+	default:
+	}
+
+	switch client.wsServer.options.SlowConsumerPolicy {
+	case DropOldest:
+		select {
+		case <-client.send:
+			client.metrics.dropped.Add(1)
+		default:
+		}
+
+		select {
+		case client.send <- message:
+			client.metrics.queued(len(client.send))
+		default:
+			client.metrics.dropped.Add(1)
+		}
+	case DisconnectSlow:
+		client.metrics.dropped.Add(1)
+		client.close(client.wsServer.options.SlowConsumerCode, slowConsumerReason)
+	default:
+		client.metrics.dropped.Add(1)
+	}
+}
+
+// close tells the client why it is disconnected and closes the connection
+// without writing the queued frames. The read pump or the HTTP transport then
+// unregisters the client.
+func (client *Client) close(code int, reason string) {
+	client.closeWith(code, reason, false)
+}
+
+// goAway closes the connection once the queued frames are written.
+func (client *Client) goAway(code int, reason string) {
+	client.closeWith(code, reason, true)
+}
+
+func (client *Client) closeWith(code int, reason string, flush bool) {
+	client.closeOnce.Do(func() {
+		log.Printf("client %s of user %v: closing, %s", client.GetID(), client.GetUserID(), reason)
+
+		client.closeCode = code
+		client.closeReason = reason
+		client.closeFlush = flush
+		close(client.done)
+	})
+}
+
+// drainQueue takes the frames queued for the client without waiting.
+func (client *Client) drainQueue() []WebsocketMessage {
+	var frames []WebsocketMessage
+	for {
+		select {
+		case message, ok := <-client.send:
+			if !ok {
+				return frames
+			}
+			frames = append(frames, message.versioned())
+		default:
+			return frames
+		}
+	}
+}
+
+// closeSend closes the queue once the client is gone, frames sent after that
+// are discarded.
+func (client *Client) closeSend() {
+	client.sendLock.Lock()
+	defer client.sendLock.Unlock()
+
+	if !client.closed {
+		client.closed = true
+		close(client.send)
+	}
+}
+
+func (client *Client) isClosed() bool {
+	client.sendLock.RLock()
+	defer client.sendLock.RUnlock()
+
+	return client.closed
+}
+
+func (client *Client) QueueStats() QueueStats {
+	return QueueStats{
+		ConnID:    client.GetID(),
+		UserID:    client.GetUserID(),
+		Len:       len(client.send),
+		Cap:       cap(client.send),
+		HighWater: int(client.metrics.highWater.Load()),
+		Enqueued:  client.metrics.enqueued.Load(),
+		Dropped:   client.metrics.dropped.Load(),
+	}
+}
+
+// QueueStats returns the send queue metrics of the connections of this node.
+func (server *WsServer) QueueStats() []QueueStats {
+	clients := server.localClients()
+
+	stats := make([]QueueStats, 0, len(clients))
+	for _, client := range clients {
+		stats = append(stats, client.QueueStats())
+	}
+
+	return stats
+}
+
+// logQueueStats reports the send queues of the connections of this node: the
+// totals, then the connections that dropped the most frames.
+func (server *WsServer) logQueueStats() {
+	stats := server.QueueStats()
+	if len(stats) == 0 {
+		return
+	}
+
+	var queued, highWater int
+	var dropped uint64
+	for _, connStats := range stats {
+		queued += connStats.Len
+		if connStats.HighWater > highWater {
+			highWater = connStats.HighWater
+		}
+		dropped += connStats.Dropped
+	}
+	log.Printf("send queues: %v connections, %v frames queued, high water %v, %v frames dropped",
+		len(stats), queued, highWater, dropped)
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Dropped > stats[j].Dropped
+	})
+	for i := 0; i < len(stats) && i < maxReportedQueues && stats[i].Dropped > 0; i++ {
+		connStats := stats[i]
+		log.Printf("client %s of user %v: %v of %v frames dropped, queue %v/%v, high water %v",
+			connStats.ConnID, connStats.UserID, connStats.Dropped, connStats.Enqueued+connStats.Dropped,
+			connStats.Len, connStats.Cap, connStats.HighWater)
+	}
+}
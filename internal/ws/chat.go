@@ -1,9 +1,12 @@
 package ws
 
 import (
+	"chatie/internal/broker"
 	"chatie/internal/models"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -11,61 +14,166 @@ import (
 const welcomeMessage = "%s joined the room"
 const leaveMessage = "%s left the room"
 
+// Size of the queue of frames waiting to be published to the chat channel
+const chatPublishQueueSize = 256
+
 type WsChat struct {
-	id         uuid.UUID
-	name       string
-	chatID     int
-	clients    map[*Client]bool
-	register   chan *Client
-	unregister chan *Client
-	broadcast  chan *WebsocketMessage
-	private    bool
-	wsServer   *WsServer
-}
-
-func NewChat(wsServer *WsServer, name string, private bool) *WsChat {
+	id            uuid.UUID
+	name          string
+	chatID        int
+	clients       map[*Client]bool
+	register      chan *Client
+	unregister    chan *Client
+	broadcast     chan *WebsocketMessage
+	outgoing      chan *WebsocketMessage
+	resume        chan *Client
+	replays       chan *resumeReplay
+	held          map[*Client]*heldFrames // live frames of clients waiting for their replay
+	typingEvents  chan *typingEvent
+	typing        map[int]*typingState
+	private       bool
+	directUserIDs []int        // users of a direct chat
+	invited       map[int]bool // users of a direct chat invited by the room
+	invitedLock   sync.Mutex
+	idleSince     time.Time // when the last client left
+	quit          chan struct{}
+	stopOnce      sync.Once
+	done          chan struct{} // closed once Run has returned
+	wsServer      *WsServer
+}
+
+func NewChat(wsServer *WsServer, chatID int, name string, private bool) *WsChat {
 	return &WsChat{
-		id:         uuid.New(),
-		name:       name,
-		clients:    make(map[*Client]bool),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		broadcast:  make(chan *WebsocketMessage),
-		private:    private,
-		wsServer:   wsServer,
+		id:           uuid.New(),
+		name:         name,
+		chatID:       chatID,
+		clients:      make(map[*Client]bool),
+		register:     make(chan *Client),
+		unregister:   make(chan *Client),
+		broadcast:    make(chan *WebsocketMessage),
+		outgoing:     make(chan *WebsocketMessage, chatPublishQueueSize),
+		resume:       make(chan *Client),
+		replays:      make(chan *resumeReplay),
+		held:         make(map[*Client]*heldFrames),
+		typingEvents: make(chan *typingEvent),
+		typing:       make(map[int]*typingState),
+		invited:      make(map[int]bool),
+		private:      private,
+		idleSince:    time.Now(),
+		quit:         make(chan struct{}),
+		done:         make(chan struct{}),
+		wsServer:     wsServer,
 	}
 }
 
+// Run owns the clients of the room: every change of the room and the fan-out
+// of the chat channel to the clients happen on this goroutine. Frames are
+// published by a separate goroutine, so the room never waits for its own
+// subscription to drain.
+//
+// The room stops when it is told to, once it has had no clients for the
+// idle timeout or when its subscription ends. It is then removed from the
+// server before done is closed, so the clients that still hold it find or
+// create a new one. A room that can't subscribe stops right away and never
+// takes clients in.
 func (c *WsChat) Run() {
-	go c.subscribeToChatMessages()
+	defer close(c.done)
+	defer c.wsServer.removeChat(c)
+
+	subscription, err := c.subscribeToChatMessages()
+	if err != nil {
+		return
+	}
+	defer subscription.Unsubscribe()
+	messages := subscription.Channel()
+
+	go c.publishLoop()
+
+	typingTicker := time.NewTicker(typingCheckPeriod)
+	defer typingTicker.Stop()
+
+	var idleCheck <-chan time.Time
+	if idleTimeout := c.wsServer.options.RoomIdleTimeout; idleTimeout > 0 {
+		idleTicker := time.NewTicker(idleTimeout / 2)
+		defer idleTicker.Stop()
+		idleCheck = idleTicker.C
+	}
 
 	for {
 		select {
+		case <-c.quit:
+			return
+		case <-idleCheck:
+			if len(c.clients) == 0 && len(c.held) == 0 && time.Since(c.idleSince) >= c.wsServer.options.RoomIdleTimeout {
+				return
+			}
 		case client := <-c.register:
 			c.registerClientInChat(client)
 		case client := <-c.unregister:
 			c.unregisterClientInChat(client)
-		case message := <-c.broadcast:
-			c.publishChatMessage(message.encode())
+		case client := <-c.resume:
+			c.holdClient(client)
+		case replay := <-c.replays:
+			c.resumeClient(replay)
+		case payload, ok := <-messages:
+			if !ok {
+				log.Printf("chat %s: subscription closed", c.GetName())
+				return
+			}
+			message, err := c.wsServer.decodePayload(payload)
+			if err != nil {
+				log.Printf("chat %s: can't decode message: %s", c.GetName(), err)
+				continue
+			}
+			if message.Action == ChatDeletedAction {
+				// the chat is gone, so is its room on every node. The
+				// members are told through their user channels.
+				return
+			}
+			c.broadcastToChatClients(message)
+		case event := <-c.typingEvents:
+			c.handleTyping(event)
+		case <-typingTicker.C:
+			c.expireTyping()
 		}
 	}
 }
 
-func (c *WsChat) publishChatMessage(message []byte) {
-	err := c.wsServer.redis.Publish(ctx, c.GetName(), message).Err()
-	if err != nil {
-		log.Println(err)
+func (c *WsChat) publishLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case message := <-c.broadcast:
+			c.publishChatMessage(message)
+		case message := <-c.outgoing:
+			c.publishChatMessage(message)
+		}
 	}
 }
 
-func (c *WsChat) subscribeToChatMessages() {
-	pubsub := c.wsServer.redis.Subscribe(ctx, c.GetName())
+// enqueueChatMessage hands a frame created by the room itself over to the
+// publisher. Such frames are notices, they are dropped if the queue is full.
+func (c *WsChat) enqueueChatMessage(message *WebsocketMessage) {
+	select {
+	case c.outgoing <- message:
+	default:
+		log.Printf("chat %s: publish queue is full, notice dropped", c.GetName())
+	}
+}
 
-	ch := pubsub.Channel()
+func (c *WsChat) publishChatMessage(message *WebsocketMessage) {
+	c.wsServer.publish(c.GetName(), message)
+}
 
-	for msg := range ch {
-		c.broadcastToChatClients([]byte(msg.Payload))
+func (c *WsChat) subscribeToChatMessages() (broker.Subscription, error) {
+	subscription, err := c.wsServer.broker.Subscribe(ctx, c.GetName())
+	if err != nil {
+		log.Printf("can't subscribe to chat %s: %s", c.GetName(), err)
+		return nil, err
 	}
+
+	return subscription, nil
 }
 
 func (c *WsChat) registerClientInChat(client *Client) {
@@ -77,49 +185,138 @@ func (c *WsChat) registerClientInChat(client *Client) {
 	c.clients[client] = true
 }
 
+// claimInvite reports whether the user of the direct chat has yet to be
+// invited by the room, which happens once while it runs. Connections that
+// come later join the chat on their own, by opening or resuming it.
+func (c *WsChat) claimInvite(userID int) bool {
+	c.invitedLock.Lock()
+	defer c.invitedLock.Unlock()
+
+	if c.invited[userID] {
+		return false
+	}
+	c.invited[userID] = true
+
+	return true
+}
+
+// stop tells the room to stop, done is closed once it has.
+func (c *WsChat) stop() {
+	c.stopOnce.Do(func() {
+		close(c.quit)
+	})
+}
+
+// join registers the client in the room. It returns false if the room has
+// stopped.
+func (c *WsChat) join(client *Client) bool {
+	select {
+	case c.register <- client:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+func (c *WsChat) leave(client *Client) {
+	select {
+	case c.unregister <- client:
+	case <-c.done:
+	}
+}
+
+// requestResume has the room hold the live frames for the client while what
+// it missed is loaded. It returns false if the room has stopped.
+func (c *WsChat) requestResume(client *Client) bool {
+	select {
+	case c.resume <- client:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// completeResume hands the loaded replay to the room, which sends it and
+// registers the client. It returns false if the room has stopped.
+func (c *WsChat) completeResume(replay *resumeReplay) bool {
+	select {
+	case c.replays <- replay:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// send publishes the message of a client to the room.
+func (c *WsChat) send(message *WebsocketMessage) {
+	select {
+	case c.broadcast <- message:
+	case <-c.done:
+	}
+}
+
+func (c *WsChat) sendTyping(event *typingEvent) {
+	select {
+	case c.typingEvents <- event:
+	case <-c.done:
+	}
+}
+
 func (c *WsChat) unregisterClientInChat(client *Client) {
+	delete(c.held, client)
 	if _, ok := c.clients[client]; ok {
 		delete(c.clients, client)
 		c.notifyClientLeft(client)
+		if len(c.clients) == 0 {
+			c.idleSince = time.Now()
+		}
 		// c.removeUserFromOnlineSet(client)
 		// fmt.Println("[char]", c.GetName(), " clients left", c.clients)
 	}
 }
 
-func (c *WsChat) broadcastToChatClients(message []byte) {
+func (c *WsChat) broadcastToChatClients(message *WebsocketMessage) {
 	for client := range c.clients {
-		client.send <- message
+		client.enqueue(message)
+	}
+
+	for _, held := range c.held {
+		held.add(message)
 	}
 }
 
 func (c *WsChat) notifyClientJoined(client *Client) {
 	message := &WebsocketMessage{
 		Action: UserJoinedAction,
-		Target: c.GetID(),
+		Target: c.GetName(),
 		Message: &models.Message{
 			Text: fmt.Sprintf(welcomeMessage, client.GetName()),
 		},
 	}
 
-	c.publishChatMessage(message.encode())
+	c.enqueueChatMessage(message)
 }
 
 func (c *WsChat) notifyClientLeft(client *Client) {
 	message := &WebsocketMessage{
 		Action: UserLeftAction,
-		Target: c.GetID(),
+		Target: c.GetName(),
 		Message: &models.Message{
 			Text: fmt.Sprintf(leaveMessage, client.GetName()),
 		},
 	}
 
-	c.publishChatMessage(message.encode())
+	c.enqueueChatMessage(message)
 }
 
 func (c *WsChat) GetID() string {
 	return c.id.String()
 }
 
+func (c *WsChat) GetChatID() int {
+	return c.chatID
+}
+
 func (c *WsChat) GetName() string {
 	return c.name
 }
@@ -1,9 +1,15 @@
 package ws
 
 import (
+	"chatie/internal/apperror"
 	"chatie/internal/models"
-	"encoding/json"
+	"chatie/internal/presence"
+	"errors"
 	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -17,7 +23,7 @@ const (
 	writeWait = 10 * time.Second
 
 	// Max time till next pong from peer
-	pongWait = 1 * time.Second
+	pongWait = 60 * time.Second
 
 	// Send ping interval, must be less then pong wait time
 	pingPeriod = (pongWait * 9) / 10
@@ -26,37 +32,61 @@ const (
 	maxMessageSize = 4096
 )
 
-var (
-	newline = []byte{'\n'}
-	space   = []byte{' '}
-)
-
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  0,
 	WriteBufferSize: 0,
+	Subprotocols:    subprotocols(),
 }
 
-// Client represents the websocket client at the server
+// Client represents the websocket client at the server. Clients of the
+// fallback HTTP transports have no websocket connection, their frames are
+// read from the send queue by the SSE stream or the polls.
 type Client struct {
-	conn     *websocket.Conn
-	wsServer *WsServer
-	send     chan []byte
-	id       uuid.UUID
-	userID   int
-	name     string
-	wsChats  map[*WsChat]bool
-	t        time.Time
+	conn      *websocket.Conn
+	transport string // fallback transport of clients without conn
+	pollState
+	done      chan struct{} // closed when the client is told to go away
+	wsServer  *WsServer
+	send      chan *WebsocketMessage
+	sendLock  sync.RWMutex // guards closing the send queue
+	closed    bool
+	metrics   queueMetrics
+	closeOnce sync.Once
+	// why the client is told to go away, set before done is closed
+	closeCode   int
+	closeReason string
+	closeFlush  bool
+	// serializes the requests, the direct chat invites and the disconnect
+	requestLock    sync.Mutex
+	disconnectOnce sync.Once
+	limiter        *tokenBucket // frames of this connection
+	// limited requests counted by the read pump
+	violations      int
+	violationsSince time.Time
+	codec           Codec // encoding negotiated with the subprotocol
+	id              uuid.UUID
+	userID          int
+	name            string
+	user            *models.User // public profile sent as the sender of frames
+	status          atomic.Value // presence status of this connection
+	wsChats         map[*WsChat]bool
+	t               time.Time
 }
 
-func newClient(conn *websocket.Conn, wsServer *WsServer, userID int) *Client {
+func newClient(conn *websocket.Conn, wsServer *WsServer, user *models.User, codec Codec) *Client {
 	return &Client{
-		id:     uuid.New(),
-		userID: userID,
-		// name:     name,
+		id:       uuid.New(),
+		userID:   user.ID,
+		name:     user.Username,
+		user:     user.Profile(),
 		conn:     conn,
+		done:     make(chan struct{}),
 		wsServer: wsServer,
-		send:     make(chan []byte, 256),
-		wsChats:  make(map[*WsChat]bool),
+		send:     make(chan *WebsocketMessage, wsServer.options.SendQueueSize),
+		codec:    codec,
+		limiter: newTokenBucket(
+			wsServer.options.RateLimits.ConnectionRate, wsServer.options.RateLimits.ConnectionBurst),
+		wsChats: make(map[*WsChat]bool),
 	}
 }
 
@@ -71,7 +101,7 @@ func (client *Client) readPump() {
 
 	// Start endless read loop, waiting for messages from client
 	for {
-		_, jsonMessage, err := client.conn.ReadMessage()
+		_, data, err := client.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("unexpected close error: %v", err)
@@ -79,7 +109,9 @@ func (client *Client) readPump() {
 			break
 		}
 		client.t = time.Now()
-		client.handleNewMessage(jsonMessage)
+		client.requestLock.Lock()
+		client.handleNewMessage(data)
+		client.requestLock.Unlock()
 	}
 
 }
@@ -100,20 +132,9 @@ func (client *Client) writePump() {
 				return
 			}
 
-			w, err := client.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// Attach queued chat messages to the current websocket message.
-			n := len(client.send)
-			for i := 0; i < n; i++ {
-				w.Write(newline)
-				w.Write(<-client.send)
-			}
-
-			if err := w.Close(); err != nil {
+			// Send the queued frames along with the current one as a batch.
+			frames := append([]WebsocketMessage{message.versioned()}, client.drainQueue()...)
+			if err := client.writeFrames(frames); err != nil {
 				return
 			}
 
@@ -124,22 +145,77 @@ func (client *Client) writePump() {
 			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+		case <-client.done:
+			client.writeClose()
+			return
+		}
+	}
+}
+
+// writeFrames writes the frames in a single message, as a batch if there are
+// several of them.
+func (client *Client) writeFrames(frames []WebsocketMessage) error {
+	frame := &frames[0]
+	if len(frames) > 1 {
+		frame = newBatch(frames)
+	}
+
+	data, err := client.codec.Marshal(frame)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	return client.conn.WriteMessage(client.codec.MessageType(), data)
+}
+
+// writeClose writes the close frame of a client told to go away, after the
+// queued frames if it is closed gracefully.
+func (client *Client) writeClose() {
+	client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+
+	if client.closeFlush {
+		if frames := client.drainQueue(); len(frames) > 0 {
+			if err := client.writeFrames(frames); err != nil {
+				return
+			}
 		}
 	}
+
+	closeMessage := websocket.FormatCloseMessage(client.closeCode, client.closeReason)
+	client.conn.WriteMessage(websocket.CloseMessage, closeMessage)
 }
 
 func (client *Client) disconnect() {
-	client.wsServer.unregister <- client
+	client.disconnectOnce.Do(client.leave)
+}
+
+func (client *Client) leave() {
+	client.requestLock.Lock()
+	defer client.requestLock.Unlock()
+
+	client.wsServer.removePresence(client)
+	client.wsServer.unregisterClient(client)
 	for chat := range client.wsChats {
-		chat.unregister <- client
+		chat.leave(client)
+	}
+	if client.conn != nil {
+		client.conn.Close()
+	}
+	client.closeSend()
+
+	if stats := client.QueueStats(); stats.Dropped > 0 {
+		log.Printf("client %s of user %v: %v of %v frames dropped, queue high water %v/%v",
+			stats.ConnID, stats.UserID, stats.Dropped, stats.Enqueued+stats.Dropped, stats.HighWater, stats.Cap)
 	}
-	client.conn.Close()
-	close(client.send)
 	// client.conn.Close()
 }
 
 func ServeWS(wsServer *WsServer, c *gin.Context) {
-	userID := c.MustGet("userID").(int)
+	user, ok := requestUser(wsServer, c)
+	if !ok {
+		return
+	}
 
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -147,135 +223,439 @@ func ServeWS(wsServer *WsServer, c *gin.Context) {
 		return
 	}
 
-	client := newClient(conn, wsServer, userID)
+	client := newClient(conn, wsServer, user, codecForSubprotocol(conn.Subprotocol()))
+	wsServer.connectClient(client)
 
 	go client.writePump()
 	go client.readPump()
+}
+
+// requestUser loads the authenticated user of a new client, answering the
+// request with an error if that fails or the server is shutting down.
+func requestUser(wsServer *WsServer, c *gin.Context) (*models.User, bool) {
+	if wsServer.shuttingDown.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": shutdownReason})
+		return nil, false
+	}
+
+	userID := c.MustGet("userID").(int)
+
+	user, err := wsServer.userRepository.GetByID(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, apperror.ErrUserNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": apperror.ErrNotAuthorized.Error()})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": apperror.ErrInternal.Error()})
+		return nil, false
+	}
+
+	return user, true
+}
 
-	wsServer.register <- client
+// connectClient marks the user online and registers the client, whatever its
+// transport.
+func (server *WsServer) connectClient(client *Client) {
+	if err := server.setPresence(client, presence.StatusOnline); err != nil {
+		log.Println("{presence}", err)
+	}
+
+	server.registerClient(client)
 }
 
-func (client *Client) handleNewMessage(jsonMessage []byte) {
+func (client *Client) handleNewMessage(data []byte) {
 	var message WebsocketMessage
-	if err := json.Unmarshal(jsonMessage, &message); err != nil {
-		log.Printf("Error on unmarshal JSON message %s", err)
+	if err := client.codec.Unmarshal(data, &message); err != nil {
+		client.sendError(message, errBadRequest)
 		return
 	}
 
+	client.handleRequest(message)
+}
+
+// handleRequest runs the action of the request and answers it with exactly
+// one ack or error frame carrying the request id.
+func (client *Client) handleRequest(message WebsocketMessage) {
+	if message.Version > ProtocolVersion {
+		client.sendError(message, errUnsupportedVersion)
+		return
+	}
+
+	if message.Action != BatchAction {
+		if err := client.checkRateLimit(message); err != nil {
+			client.sendError(message, err)
+			return
+		}
+	}
+
 	message.Sender = clientToUser(client)
 
-	// log.Println("new event ", message.Action, message.Sender, message.Target, message.Message)
+	var result any
+	var err error
 
 	switch message.Action {
+	case BatchAction:
+		err = client.handleBatch(message)
 	case SendMessageAction:
-		client.handleSendMessage(message)
+		result, err = client.handleSendMessage(message)
 	case JoinChatAction:
-		client.handleJoinChatMessage(message)
+		err = client.handleJoinChatMessage(message)
 	case LeaveChatAction:
-		client.handleLeaveChatMessage(message)
+		err = client.handleLeaveChatMessage(message)
 	case JoinChatPrivateAction:
-		client.handleJoinChatPrivateMessage(message)
+		result, err = client.handleJoinChatPrivateMessage(message)
 	case GetChatUsersAction:
-		chatID := message.Target
-		if chat := client.wsServer.findChatByName(chatID); chat != nil {
-			if client.isInChat(chat) {
-				data := SystemMessage{
-					Action: "get-chat-users",
-					Data:   chat.clients,
-				}
-
-				client.send <- data.encode()
-			}
-		}
+		result, err = client.handleGetChatUsersMessage(message)
+	case GetHistoryAction:
+		result, err = client.handleGetHistoryMessage(message)
+	case GetThreadAction:
+		result, err = client.handleGetThreadMessage(message)
+	case SetPresenceAction:
+		err = client.handleSetPresenceMessage(message)
+	case GetOnlineUsersAction:
+		result, err = client.handleGetOnlineUsersMessage(message)
+	case TypingStartAction, TypingStopAction:
+		err = client.handleTypingMessage(message)
+	case MarkReadAction:
+		result, err = client.handleMarkReadMessage(message)
+	case EditMessageAction:
+		result, err = client.handleEditMessage(message)
+	case DeleteMessageAction:
+		result, err = client.handleDeleteMessage(message)
+	case AddReactionAction, RemoveReactionAction:
+		result, err = client.handleReactionMessage(message)
+	case KickMemberAction, BanMemberAction, UnbanMemberAction, MuteMemberAction,
+		UnmuteMemberAction, PromoteMemberAction, DemoteMemberAction:
+		result, err = client.handleModerationMessage(message)
+	case ResumeAction:
+		result, err = client.handleResumeMessage(message)
 	default:
-		client.send <- message.encode()
+		err = errUnknownAction
+	}
+
+	if err != nil {
+		client.sendError(message, err)
+		return
+	}
+
+	if message.Action != BatchAction {
+		client.sendAck(message, result)
 	}
 }
 
-func (client *Client) handleSendMessage(message WebsocketMessage) {
-	chatID := message.Target
-	if chat := client.wsServer.findChatByName(chatID); chat != nil {
-		if client.isInChat(chat) {
-			chat.broadcast <- &message
-		} else {
-			message := SystemMessage{
-				Action: SendMessageAction,
-				Data:   "illegal action",
-			}
-			client.send <- message.encode()
+// handleBatch runs every request of a batch in order, each of them is
+// answered separately.
+func (client *Client) handleBatch(message WebsocketMessage) error {
+	var requests []WebsocketMessage
+	if err := message.decodeData(&requests); err != nil {
+		return errBadRequest
+	}
+
+	for _, request := range requests {
+		if request.Action == BatchAction {
+			client.sendError(request, errBadRequest)
+			continue
 		}
+		client.handleRequest(request)
 	}
+
+	return nil
 }
 
-func (client *Client) handleJoinChatMessage(message WebsocketMessage) {
-	chatName := message.Message.Text
+func (client *Client) handleSendMessage(message WebsocketMessage) (*models.Message, error) {
+	chat := client.findJoinedChat(message.Target)
+	if chat == nil {
+		return nil, apperror.ErrNotAuthorized
+	}
+	if message.Message == nil {
+		return nil, errBadRequest
+	}
 
-	client.joinChat(chatName, nil)
+	savedMessage, err := client.wsServer.messageService.SaveMessage(
+		ctx, message.Message, chat.GetChatID(), client.GetUserID())
+	if err != nil {
+		return nil, err
+	}
+
+	message.Message = savedMessage
+	message.RequestID = ""
+	chat.send(&message)
+	chat.sendTyping(&typingEvent{user: message.Sender})
+
+	go client.wsServer.notifyMentions(savedMessage)
+	for _, userID := range chat.directUserIDs {
+		if userID != client.GetUserID() && chat.claimInvite(userID) {
+			client.wsServer.inviteToDirectChat(userID, savedMessage)
+		}
+	}
+	if savedMessage.ThreadID != 0 {
+		go client.wsServer.notifyThread(savedMessage)
+	}
+
+	return savedMessage, nil
 }
 
-func (client *Client) handleLeaveChatMessage(message WebsocketMessage) {
-	chat := client.wsServer.findChatByName(message.Target)
+func (client *Client) handleEditMessage(message WebsocketMessage) (*models.Message, error) {
+	chatID, err := strconv.Atoi(message.Target)
+	if err != nil {
+		return nil, apperror.ErrChatNotFound
+	}
+	if message.Message == nil {
+		return nil, errBadRequest
+	}
+
+	editedMessage, err := client.wsServer.messageService.EditMessage(
+		ctx, chatID, client.GetUserID(), message.Message.ID, message.Message.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	client.wsServer.NotifyChat(chatID, MessageEditedAction, editedMessage)
+
+	return editedMessage, nil
+}
+
+func (client *Client) handleDeleteMessage(message WebsocketMessage) (*models.Message, error) {
+	chatID, err := strconv.Atoi(message.Target)
+	if err != nil {
+		return nil, apperror.ErrChatNotFound
+	}
+	if message.Message == nil {
+		return nil, errBadRequest
+	}
+
+	deletedMessage, err := client.wsServer.messageService.DeleteMessage(
+		ctx, chatID, client.GetUserID(), message.Message.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	client.wsServer.NotifyChat(chatID, MessageDeletedAction, deletedMessage)
+
+	return deletedMessage, nil
+}
+
+func (client *Client) handleReactionMessage(message WebsocketMessage) (*models.ReactionsUpdate, error) {
+	var request reactionRequest
+	if err := message.decodeData(&request); err != nil {
+		return nil, errBadRequest
+	}
+
+	chatID, err := strconv.Atoi(message.Target)
+	if err != nil {
+		return nil, apperror.ErrChatNotFound
+	}
+
+	var update *models.ReactionsUpdate
+	if message.Action == AddReactionAction {
+		update, err = client.wsServer.messageService.AddReaction(
+			ctx, chatID, client.GetUserID(), request.MessageID, request.Emoji)
+	} else {
+		update, err = client.wsServer.messageService.RemoveReaction(
+			ctx, chatID, client.GetUserID(), request.MessageID, request.Emoji)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client.wsServer.NotifyChat(chatID, ReactionsUpdatedAction, update)
+
+	return update, nil
+}
+
+func (client *Client) handleModerationMessage(message WebsocketMessage) (*models.Moderation, error) {
+	var request moderationRequest
+	if err := message.decodeData(&request); err != nil {
+		return nil, errBadRequest
+	}
+
+	chatID, err := strconv.Atoi(message.Target)
+	if err != nil {
+		return nil, apperror.ErrChatNotFound
+	}
+
+	moderation, err := client.wsServer.chatService.Moderate(ctx, models.Moderation{
+		Action:   moderationActions[message.Action],
+		ChatID:   chatID,
+		UserID:   request.UserID,
+		ActorID:  client.GetUserID(),
+		Duration: request.Duration,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client.wsServer.ApplyModeration(moderation)
+
+	return moderation, nil
+}
+
+func (client *Client) handleGetChatUsersMessage(message WebsocketMessage) ([]*models.ChatUser, error) {
+	chat := client.findJoinedChat(message.Target)
 	if chat == nil {
-		return
+		return nil, apperror.ErrNotAuthorized
 	}
 
-	delete(client.wsChats, chat)
+	members, err := client.wsServer.chatRepository.GetChatMembersByID(ctx, chat.GetChatID())
+	if err != nil {
+		log.Println("{chat users}", err)
+		return nil, apperror.ErrInternal
+	}
 
-	chat.unregister <- client
+	profiles := make([]*models.ChatUser, 0, len(members))
+	for i := range members {
+		if members[i].IsBanned {
+			continue
+		}
+		profiles = append(profiles, members[i].Profile())
+	}
+
+	return profiles, nil
 }
 
-func (client *Client) handleJoinChatPrivateMessage(message WebsocketMessage) {
-	target := client.wsServer.findClientByID(message.Target)
+func (client *Client) handleGetHistoryMessage(message WebsocketMessage) ([]models.Message, error) {
+	var request historyRequest
+	if err := message.decodeData(&request); err != nil {
+		return nil, errBadRequest
+	}
 
-	if target == nil {
-		return
+	chatID, err := strconv.Atoi(message.Target)
+	if err != nil {
+		return nil, apperror.ErrChatNotFound
+	}
+
+	return client.wsServer.messageService.GetHistory(
+		ctx, chatID, client.GetUserID(), request.Before, request.Limit)
+}
+
+func (client *Client) handleGetThreadMessage(message WebsocketMessage) (*models.Thread, error) {
+	var request threadRequest
+	if err := message.decodeData(&request); err != nil {
+		return nil, errBadRequest
+	}
+
+	chatID, err := strconv.Atoi(message.Target)
+	if err != nil {
+		return nil, apperror.ErrChatNotFound
+	}
+
+	return client.wsServer.messageService.GetThread(
+		ctx, chatID, client.GetUserID(), request.MessageID, request.After, request.Limit)
+}
+
+func (client *Client) handleMarkReadMessage(message WebsocketMessage) (*models.ReadReceipt, error) {
+	var request markReadRequest
+	if err := message.decodeData(&request); err != nil {
+		return nil, errBadRequest
 	}
 
-	chatName := message.Target + client.GetID()
+	chatID, err := strconv.Atoi(message.Target)
+	if err != nil {
+		return nil, apperror.ErrChatNotFound
+	}
+
+	receipt, err := client.wsServer.messageService.MarkRead(
+		ctx, chatID, client.GetUserID(), request.MessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	client.wsServer.NotifyChat(chatID, ReadUpdatedAction, receipt)
 
-	// client.joinChat(chatName, target)
-	// target.joinRoom(chatName, client)
-	joinedChat := client.joinChat(chatName, target)
+	return receipt, nil
+}
+
+func (client *Client) handleJoinChatMessage(message WebsocketMessage) error {
+	chatID, err := strconv.Atoi(message.Target)
+	if err != nil {
+		return apperror.ErrChatNotFound
+	}
 
-	if joinedChat != nil {
-		client.inviteTargetUser(target.GetName(), joinedChat)
+	chat, err := client.wsServer.authorizeChatMember(chatID, client.GetUserID())
+	if err != nil {
+		return err
 	}
+
+	return client.enterPersistedChat(chat)
 }
 
-func (client *Client) joinChat(chatName string, sender *Client) *WsChat {
-	chat := client.wsServer.findChatByName(chatName)
+func (client *Client) handleLeaveChatMessage(message WebsocketMessage) error {
+	chat := client.findJoinedChat(message.Target)
 	if chat == nil {
-		chat = client.wsServer.createChat(chatName, sender != nil)
+		return apperror.ErrChatNotFound
 	}
 
-	if sender == nil && chat.IsPrivate() {
-		return nil
+	delete(client.wsChats, chat)
+
+	chat.leave(client)
+
+	return nil
+}
+
+// handleJoinChatPrivateMessage opens the direct chat with the user in the
+// target and joins it. The other user is invited to it on every node.
+func (client *Client) handleJoinChatPrivateMessage(message WebsocketMessage) (*models.Chat, error) {
+	peerID, err := strconv.Atoi(message.Target)
+	if err != nil {
+		return nil, apperror.ErrUserNotFound
 	}
 
-	if !client.isInChat(chat) {
-		client.wsChats[chat] = true
-		chat.register <- client
+	chat, err := client.wsServer.chatService.OpenDirectChat(ctx, client.GetUserID(), peerID)
+	if err != nil {
+		return nil, err
+	}
 
-		client.notifyChatJoined(chat, sender)
+	if err := client.enterPersistedChat(chat); err != nil {
+		return nil, err
 	}
 
-	return chat
+	client.wsServer.InviteToChat(chat.ID, peerID)
+
+	return chat, nil
 }
 
-func (client *Client) inviteTargetUser(targetID string, chat *WsChat) {
-	inviteMessage := &WebsocketMessage{
-		Action: JoinChatPrivateAction,
-		Message: &models.Message{
-			Text: targetID,
-		},
-		Target: chat.GetID(),
-		Sender: clientToUser(client),
+// enterChat joins the room, it returns false if the room has stopped.
+func (client *Client) enterChat(chat *WsChat) bool {
+	if client.isInChat(chat) {
+		return true
 	}
 
-	if err := client.wsServer.redis.Publish(ctx, PubSubGeneralChannel, inviteMessage.encode()).Err(); err != nil {
-		// log.Println(err)
-		return
+	if !chat.join(client) {
+		return false
+	}
+	client.wsChats[chat] = true
+
+	client.notifyChatJoined(chat)
+
+	return true
+}
+
+// enterPersistedChat joins the room of the chat. A room found just as it was
+// torn down for being idle is replaced by a new one.
+func (client *Client) enterPersistedChat(chat *models.Chat) error {
+	for attempt := 0; attempt < 2; attempt++ {
+		if client.enterChat(client.wsServer.findOrCreateChat(chat)) {
+			return nil
+		}
 	}
+
+	return apperror.ErrInternal
+}
+
+// findJoinedChat returns the room of the persisted chat with the given id if
+// the client has joined it.
+func (client *Client) findJoinedChat(target string) *WsChat {
+	chatID, err := strconv.Atoi(target)
+	if err != nil {
+		return nil
+	}
+
+	chat := client.wsServer.findChatByID(chatID)
+	if chat == nil || !client.isInChat(chat) {
+		return nil
+	}
+
+	return chat
 }
 
 func (client *Client) isInChat(chat *WsChat) bool {
@@ -286,14 +666,14 @@ func (client *Client) isInChat(chat *WsChat) bool {
 	return false
 }
 
-func (client *Client) notifyChatJoined(chat *WsChat, sender *Client) {
+func (client *Client) notifyChatJoined(chat *WsChat) {
 	message := WebsocketMessage{
 		Action: ChatJoinedAction,
-		Target: chat.GetID(),
+		Target: chat.GetName(),
 		Sender: clientToUser(client),
 	}
 
-	client.send <- message.encode()
+	client.enqueue(&message)
 }
 
 func (client *Client) GetName() string {
@@ -307,3 +687,12 @@ func (client *Client) GetID() string {
 func (client *Client) GetUserID() int {
 	return client.userID
 }
+
+func (client *Client) GetStatus() string {
+	status, ok := client.status.Load().(string)
+	if !ok {
+		return presence.StatusOnline
+	}
+
+	return status
+}
@@ -0,0 +1,121 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+// Websocket subprotocols a client can ask for in Sec-WebSocket-Protocol.
+// Clients that don't ask for any get JSON.
+const (
+	JSONSubprotocol    = "chatie.v1.json"
+	MsgpackSubprotocol = "chatie.v1.msgpack"
+)
+
+// Codec encodes the frames of a connection and the payloads sent through the
+// broker.
+type Codec interface {
+	Name() string
+	MessageType() int // websocket message type of the encoded frames
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// NewCodec returns the codec with the given name, "json" or "msgpack".
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case "", "json":
+		return jsonCodec{}, nil
+	case "msgpack":
+		return newMsgpackCodec(), nil
+	default:
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+}
+
+// Subprotocols in the order the server prefers them.
+var subprotocolCodecs = []struct {
+	subprotocol string
+	codec       Codec
+}{
+	{JSONSubprotocol, jsonCodec{}},
+	{MsgpackSubprotocol, newMsgpackCodec()},
+}
+
+func subprotocols() []string {
+	names := make([]string, 0, len(subprotocolCodecs))
+	for _, c := range subprotocolCodecs {
+		names = append(names, c.subprotocol)
+	}
+
+	return names
+}
+
+// codecForSubprotocol returns the codec of the negotiated subprotocol, JSON if
+// none was negotiated.
+func codecForSubprotocol(subprotocol string) Codec {
+	for _, c := range subprotocolCodecs {
+		if c.subprotocol == subprotocol {
+			return c.codec
+		}
+	}
+
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// msgpackCodec uses the json tags of the models, so the frames have the same
+// field names as in JSON.
+type msgpackCodec struct {
+	handle *codec.MsgpackHandle
+}
+
+func newMsgpackCodec() *msgpackCodec {
+	handle := &codec.MsgpackHandle{}
+	handle.TypeInfos = codec.NewTypeInfos([]string{"json"})
+	handle.MapType = reflect.TypeOf(map[string]any(nil))
+	handle.RawToString = true
+	handle.WriteExt = true
+
+	return &msgpackCodec{handle: handle}
+}
+
+func (c *msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (c *msgpackCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (c *msgpackCodec) Marshal(v any) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, c.handle).Encode(v)
+
+	return data, err
+}
+
+func (c *msgpackCodec) Unmarshal(data []byte, v any) error {
+	return codec.NewDecoderBytes(data, c.handle).Decode(v)
+}
@@ -0,0 +1,296 @@
+package ws
+
+import (
+	"chatie/internal/apperror"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Fallback transports for clients that can't open a websocket: frames are
+// received through a Server-Sent Events stream or long polls and requests are
+// sent with POST. Their clients join the same rooms as websocket ones.
+
+const (
+	TransportSSE      = "sse"
+	TransportLongPoll = "long-poll"
+)
+
+const (
+	// Wait of a poll when the client doesn't ask for one
+	defaultPollWait = 25 * time.Second
+
+	// Max wait of a poll
+	maxPollWait = 50 * time.Second
+
+	// Long-poll sessions without a poll for this long are closed
+	pollSessionTimeout = pongWait
+)
+
+// Sent first on a stream or a new long-poll session, its id is used to send
+// requests and to poll.
+const SessionAction = "session"
+
+type sessionData struct {
+	SessionID string `json:"sessionID"`
+	Transport string `json:"transport"`
+}
+
+// ServeSSE streams the frames of a new client as Server-Sent Events until the
+// request is closed.
+func ServeSSE(wsServer *WsServer, c *gin.Context) {
+	user, ok := requestUser(wsServer, c)
+	if !ok {
+		return
+	}
+
+	// The stream outlives the write timeout of the server.
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		log.Println("{sse}", err)
+	}
+
+	client := newClient(nil, wsServer, user, jsonCodec{})
+	client.transport = TransportSSE
+	wsServer.connectClient(client)
+	defer client.disconnect()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	client.sendSession()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message, ok := <-client.send:
+			if !ok {
+				return
+			}
+			if err := client.writeEvent(c.Writer, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := io.WriteString(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-client.done:
+			if client.closeFlush {
+				for _, frame := range client.drainQueue() {
+					client.writeEvent(c.Writer, &frame)
+				}
+				c.Writer.Flush()
+			}
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeEvent writes the frame and the ones queued after it as events.
+func (client *Client) writeEvent(w io.Writer, message *WebsocketMessage) error {
+	for {
+		frame := message.versioned()
+		data, err := client.codec.Marshal(&frame)
+		if err != nil {
+			log.Println(err)
+		} else if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", frame.Action, data); err != nil {
+			return err
+		}
+
+		select {
+		case next, ok := <-client.send:
+			if !ok {
+				return nil
+			}
+			message = next
+		default:
+			return nil
+		}
+	}
+}
+
+// ServeLongPoll opens a long-poll session. The frames of the session are
+// fetched with ServePoll, which has to be called again within the session
+// timeout.
+func ServeLongPoll(wsServer *WsServer, c *gin.Context) {
+	user, ok := requestUser(wsServer, c)
+	if !ok {
+		return
+	}
+
+	client := newClient(nil, wsServer, user, jsonCodec{})
+	client.transport = TransportLongPoll
+	client.lastPoll.Store(time.Now().UnixNano())
+	wsServer.connectClient(client)
+
+	go client.expireLongPoll()
+
+	client.sendSession()
+	ServePoll(wsServer, c, client.GetID())
+}
+
+// expireLongPoll disconnects the session once the client stops polling.
+func (client *Client) expireLongPoll() {
+	ticker := time.NewTicker(pollSessionTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-client.done:
+			client.disconnect()
+			return
+		case <-ticker.C:
+			if client.polling.Load() {
+				continue
+			}
+			lastPoll := time.Unix(0, client.lastPoll.Load())
+			if time.Since(lastPoll) > pollSessionTimeout {
+				client.disconnect()
+				return
+			}
+		}
+	}
+}
+
+// ServePoll waits for the frames of a long-poll session and returns them in
+// a batch, which is empty if nothing arrived within the wait.
+func ServePoll(wsServer *WsServer, c *gin.Context, sessionID string) {
+	client, ok := sessionClient(wsServer, c, sessionID)
+	if !ok {
+		return
+	}
+	if client.transport != TransportLongPoll {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not a long-poll session"})
+		return
+	}
+
+	if !client.polling.CompareAndSwap(false, true) {
+		c.JSON(http.StatusConflict, gin.H{"error": "session is already polled"})
+		return
+	}
+	defer func() {
+		client.lastPoll.Store(time.Now().UnixNano())
+		client.polling.Store(false)
+	}()
+
+	wait := defaultPollWait
+	if s := c.Query("wait"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil || seconds < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wait"})
+			return
+		}
+		wait = time.Duration(seconds) * time.Second
+		if wait > maxPollWait {
+			wait = maxPollWait
+		}
+	}
+
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(wait + writeWait)); err != nil {
+		log.Println("{long-poll}", err)
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	frames := []WebsocketMessage{}
+	select {
+	case message, ok := <-client.send:
+		if !ok {
+			c.JSON(http.StatusGone, gin.H{"error": "session is closed"})
+			return
+		}
+		frames = append(frames, message.versioned())
+		frames = append(frames, client.drainQueue()...)
+	case <-timer.C:
+	case <-client.done:
+		frames = append(frames, client.drainQueue()...)
+	case <-c.Request.Context().Done():
+		return
+	}
+
+	c.JSON(http.StatusOK, newBatch(frames))
+}
+
+// ServeSend runs the request, or the batch of requests, sent by the client of
+// an SSE or long-poll session. The replies are delivered through the session.
+func ServeSend(wsServer *WsServer, c *gin.Context, sessionID string) {
+	client, ok := sessionClient(wsServer, c, sessionID)
+	if !ok {
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxMessageSize+1))
+	if err != nil || len(data) > maxMessageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errBadRequest.Error()})
+		return
+	}
+
+	client.requestLock.Lock()
+	defer client.requestLock.Unlock()
+
+	if client.isClosed() {
+		c.JSON(http.StatusGone, gin.H{"error": "session is closed"})
+		return
+	}
+
+	client.handleNewMessage(data)
+
+	c.Status(http.StatusAccepted)
+}
+
+// CloseSession disconnects the client of an SSE or long-poll session.
+func CloseSession(wsServer *WsServer, c *gin.Context, sessionID string) {
+	client, ok := sessionClient(wsServer, c, sessionID)
+	if !ok {
+		return
+	}
+
+	client.close(websocket.CloseNormalClosure, "session closed")
+	client.disconnect()
+
+	c.Status(http.StatusNoContent)
+}
+
+// sessionClient returns the client of the session if it belongs to the user
+// of the request.
+func sessionClient(wsServer *WsServer, c *gin.Context, sessionID string) (*Client, bool) {
+	userID := c.MustGet("userID").(int)
+
+	client := wsServer.findClientByID(sessionID)
+	if client == nil || client.GetUserID() != userID || client.transport == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": apperror.ErrSessionNotFound.Error()})
+		return nil, false
+	}
+
+	return client, true
+}
+
+func (client *Client) sendSession() {
+	client.enqueue(&WebsocketMessage{
+		Action: SessionAction,
+		Data: sessionData{
+			SessionID: client.GetID(),
+			Transport: client.transport,
+		},
+	})
+}
+
+// pollState tracks the polls of a long-poll client.
+type pollState struct {
+	lastPoll atomic.Int64 // unix nano
+	polling  atomic.Bool
+}
@@ -0,0 +1,141 @@
+package ws
+
+import (
+	"chatie/internal/models"
+	"log"
+	"strconv"
+)
+
+// Users are made to join chats they didn't ask for, like a direct chat opened
+// by someone else or a chat they created, and to leave chats they are removed
+// from through their user channel: the connections of the user may be on any
+// node or there may be none at all.
+
+// InviteToChat makes the connections of the user join the chat on every node.
+// Connections that are already in the chat are left alone.
+func (server *WsServer) InviteToChat(chatID int, userID int) {
+	server.publishToUser(userID, &WebsocketMessage{
+		Action: ChatInviteAction,
+		Target: chatChannel(chatID),
+	})
+}
+
+// inviteToDirectChat invites the user to the direct chat of the message. The
+// connections that join through the invite get the message from storage, as
+// it may have been fanned out before they joined.
+func (server *WsServer) inviteToDirectChat(userID int, message *models.Message) {
+	server.publishToUser(userID, &WebsocketMessage{
+		Action:  ChatInviteAction,
+		Target:  chatChannel(message.ChatID),
+		Message: message,
+	})
+}
+
+// joinInvitedChat makes the local connections of the user join the chat in
+// the target of an invite, replaying the message that came with the invite
+// if any. The membership is only checked when a connection isn't in the chat
+// yet.
+func (server *WsServer) joinInvitedChat(userID int, target string, message *models.Message) {
+	chatID, err := strconv.Atoi(target)
+	if err != nil {
+		return
+	}
+
+	var chat *models.Chat
+	for _, client := range server.userClients.Get(userID) {
+		client.requestLock.Lock()
+		if !client.isClosed() && client.findJoinedChat(target) == nil {
+			if chat == nil {
+				chat, err = server.authorizeChatMember(chatID, userID)
+			}
+			if err == nil && message != nil {
+				client.enterPersistedChatAfter(chat, message.ID-1)
+			} else if err == nil {
+				client.enterPersistedChat(chat)
+			}
+		}
+		client.requestLock.Unlock()
+	}
+}
+
+// ApplyModeration tells the members of the chat about the moderation. Kicked
+// and banned members are made to leave the chat on every node right away,
+// mutes and role changes take effect with their next request.
+func (server *WsServer) ApplyModeration(moderation *models.Moderation) {
+	server.NotifyChat(moderation.ChatID, MemberModeratedAction, moderation)
+
+	if moderation.RemovesMember() {
+		server.publishToUser(moderation.UserID, &WebsocketMessage{
+			Action: RemovedFromChatAction,
+			Target: chatChannel(moderation.ChatID),
+			Data:   moderation,
+		})
+	}
+}
+
+// leaveRemovedChat makes the local connections of the user leave the chat
+// they were removed from or that was deleted. Rooms that have already
+// stopped are dropped as well.
+func (server *WsServer) leaveRemovedChat(userID int, target string) {
+	chatID, err := strconv.Atoi(target)
+	if err != nil {
+		return
+	}
+
+	for _, client := range server.userClients.Get(userID) {
+		client.requestLock.Lock()
+		for chat := range client.wsChats {
+			if chat.GetChatID() == chatID {
+				delete(client.wsChats, chat)
+				chat.leave(client)
+			}
+		}
+		client.requestLock.Unlock()
+	}
+}
+
+// CloseChat stops the room of the deleted chat on every node and tells its
+// members about the deletion through their user channels, so the members
+// that aren't in the room right now learn about it too. Deleted chats can't
+// be joined again.
+func (server *WsServer) CloseChat(chat *models.Chat) {
+	server.NotifyChat(chat.ID, ChatDeletedAction, chat)
+
+	server.publishToMembers(chat, &WebsocketMessage{
+		Action: ChatDeletedAction,
+		Target: chatChannel(chat.ID),
+		Data:   chat,
+	})
+}
+
+// ReopenChat makes the connections of the members of the restored chat join
+// it again and tells them about it.
+func (server *WsServer) ReopenChat(chat *models.Chat) {
+	server.publishToMembers(chat, &WebsocketMessage{
+		Action: ChatInviteAction,
+		Target: chatChannel(chat.ID),
+	}, &WebsocketMessage{
+		Action: ChatRestoredAction,
+		Target: chatChannel(chat.ID),
+		Data:   chat,
+	})
+}
+
+// publishToMembers sends the messages in order to the user channels of the
+// members of the chat that aren't banned from it.
+func (server *WsServer) publishToMembers(chat *models.Chat, messages ...*WebsocketMessage) {
+	members, err := server.chatRepository.GetChatMembersByID(ctx, chat.ID)
+	if err != nil {
+		log.Printf("can't get the members of chat %d: %s", chat.ID, err)
+		return
+	}
+
+	for _, member := range members {
+		if member.IsBanned {
+			continue
+		}
+		for _, message := range messages {
+			server.publishToUser(member.ID, message)
+		}
+	}
+}
@@ -3,7 +3,6 @@ package ws
 import (
 	"chatie/internal/models"
 	"encoding/json"
-	"log"
 )
 
 // Subscribed Messages
@@ -16,33 +15,117 @@ const JoinChatPrivateAction = "join-chat-private"
 
 const ChatJoinedAction = "chat-joined"
 const GetChatUsersAction = "get-chat-users"
+const GetHistoryAction = "get-history"
+const ErrorAction = "error"
+const SetPresenceAction = "set-presence"
+const GetOnlineUsersAction = "get-online-users"
+const PresenceChangedAction = "presence-changed"
+const TypingStartAction = "typing-start"
+const TypingStopAction = "typing-stop"
+const MarkReadAction = "mark-read"
+const ReadUpdatedAction = "read-updated"
+const EditMessageAction = "edit-message"
+const DeleteMessageAction = "delete-message"
+const MessageEditedAction = "message-edited"
+const MessageDeletedAction = "message-deleted"
+const ResumeAction = "resume"
+const ResumedAction = "resumed"
+const ResyncRequiredAction = "resync-required"
+const AckAction = "ack"
+const BatchAction = "batch"
+const MentionedAction = "mentioned"
+const AddReactionAction = "add-reaction"
+const RemoveReactionAction = "remove-reaction"
+const ReactionsUpdatedAction = "reactions-updated"
+const GetThreadAction = "get-thread"
+const ThreadUpdatedAction = "thread-updated"
+const ThreadRepliedAction = "thread-replied"
+const ChatUpdatedAction = "chat-updated"
+const MemberAddedAction = "member-added"
+const KickMemberAction = "kick-member"
+const BanMemberAction = "ban-member"
+const UnbanMemberAction = "unban-member"
+const MuteMemberAction = "mute-member"
+const UnmuteMemberAction = "unmute-member"
+const PromoteMemberAction = "promote-member"
+const DemoteMemberAction = "demote-member"
+const MemberModeratedAction = "member-moderated"
+const RemovedFromChatAction = "removed-from-chat"
+const OwnerChangedAction = "owner-changed"
+const ChatDeletedAction = "chat-deleted"
+const ChatRestoredAction = "chat-restored"
+
+// Published to user channels only, never sent to the clients
+const ChatInviteAction = "chat-invite"
 
 type WebsocketMessage struct {
-	Action  string          `json:"action"`
-	Message *models.Message `json:"message"`
-	Target  string          `json:"target"` // chat, user, channel ids
-	Sender  *models.User    `json:"sender"`
+	Version   int             `json:"v"`
+	Action    string          `json:"action"`
+	RequestID string          `json:"requestID,omitempty"` // set by the client, echoed in the reply
+	ReplyTo   string          `json:"replyTo,omitempty"`   // request id of the ack or error
+	Message   *models.Message `json:"message,omitempty"`
+	Target    string          `json:"target,omitempty"` // chat, user, channel ids
+	Sender    *models.User    `json:"sender,omitempty"`
+	Data      any             `json:"data,omitempty"` // action specific payload
+	Error     *ProtocolError  `json:"error,omitempty"`
 }
 
-type SystemMessage struct {
-	Action string `json:"action"`
-	Data   any    `json:"data"`
+type markReadRequest struct {
+	MessageID int `json:"messageID"`
 }
 
-func (message *SystemMessage) encode() []byte {
-	json, err := json.Marshal(message)
-	if err != nil {
-		log.Println(err)
-	}
+type reactionRequest struct {
+	MessageID int    `json:"messageID"`
+	Emoji     string `json:"emoji"`
+}
+
+type threadRequest struct {
+	MessageID int `json:"messageID"`
+	After     int `json:"after"`
+	Limit     int `json:"limit"`
+}
+
+type moderationRequest struct {
+	UserID   int `json:"userID"`
+	Duration int `json:"duration"` // seconds
+}
 
-	return json
+// Moderation actions of the requests
+var moderationActions = map[string]string{
+	KickMemberAction:    models.ModerationKick,
+	BanMemberAction:     models.ModerationBan,
+	UnbanMemberAction:   models.ModerationUnban,
+	MuteMemberAction:    models.ModerationMute,
+	UnmuteMemberAction:  models.ModerationUnmute,
+	PromoteMemberAction: models.ModerationPromote,
+	DemoteMemberAction:  models.ModerationDemote,
 }
 
-func (message *WebsocketMessage) encode() []byte {
-	json, err := json.Marshal(message)
+type historyRequest struct {
+	Before int `json:"before"`
+	Limit  int `json:"limit"`
+}
+
+// versioned returns a copy of the frame stamped with the protocol version.
+// Frames are shared by the clients of a chat, so they are never modified
+// once queued.
+func (message *WebsocketMessage) versioned() WebsocketMessage {
+	frame := *message
+	frame.Version = ProtocolVersion
+
+	return frame
+}
+
+// decodeData unpacks the action specific payload of the message into v.
+func (message *WebsocketMessage) decodeData(v any) error {
+	if message.Data == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(message.Data)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
 
-	return json
+	return json.Unmarshal(data, v)
 }
@@ -0,0 +1,74 @@
+package ws
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Options tune the connections of the server.
+type Options struct {
+	SendQueueSize      int           // frames queued per connection
+	SlowConsumerPolicy string        // drop-oldest, drop-newest, disconnect
+	SlowConsumerCode   int           // close code of the disconnect policy, 1008 or 1013
+	RoomIdleTimeout    time.Duration // rooms without clients are torn down after it, 0 keeps them
+	StatsInterval      time.Duration // period the send queue metrics are logged at, 0 disables them
+	RateLimits         RateLimits
+}
+
+// RateLimits are token buckets refilled at Rate tokens per second up to
+// Burst, a zero rate disables the bucket. Users and chats are limited per
+// node.
+type RateLimits struct {
+	ConnectionRate  float64 // frames of a connection
+	ConnectionBurst int
+	UserRate        float64 // frames of all the connections of a user
+	UserBurst       int
+	ChatRate        float64 // messages sent, edited or deleted in a chat
+	ChatBurst       int
+	MaxViolations   int           // limited frames before the connection is closed
+	ViolationWindow time.Duration // period the violations are counted over
+}
+
+func (o Options) Validate() error {
+	if o.SendQueueSize <= 0 {
+		return fmt.Errorf("send queue size must be positive")
+	}
+
+	switch o.SlowConsumerPolicy {
+	case DropOldest, DropNewest:
+	case DisconnectSlow:
+		if o.SlowConsumerCode != websocket.ClosePolicyViolation && o.SlowConsumerCode != websocket.CloseTryAgainLater {
+			return fmt.Errorf("slow consumer close code must be %v or %v",
+				websocket.ClosePolicyViolation, websocket.CloseTryAgainLater)
+		}
+	default:
+		return fmt.Errorf("unknown slow consumer policy %q", o.SlowConsumerPolicy)
+	}
+
+	if o.RoomIdleTimeout < 0 {
+		return fmt.Errorf("room idle timeout can't be negative")
+	}
+	if o.StatsInterval < 0 {
+		return fmt.Errorf("stats interval can't be negative")
+	}
+
+	return o.RateLimits.validate()
+}
+
+func (l RateLimits) validate() error {
+	if l.ConnectionRate < 0 || l.UserRate < 0 || l.ChatRate < 0 {
+		return fmt.Errorf("rate limits can't be negative")
+	}
+	if (l.ConnectionRate > 0 && l.ConnectionBurst < 1) ||
+		(l.UserRate > 0 && l.UserBurst < 1) ||
+		(l.ChatRate > 0 && l.ChatBurst < 1) {
+		return fmt.Errorf("rate limit bursts must be at least 1")
+	}
+	if l.MaxViolations > 0 && l.ViolationWindow <= 0 {
+		return fmt.Errorf("rate limit violation window must be positive")
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package ws
+
+import (
+	"chatie/internal/models"
+	"chatie/internal/presence"
+	"log"
+)
+
+type presenceData struct {
+	UserID int    `json:"userID"`
+	Status string `json:"status"`
+}
+
+type presenceRequest struct {
+	Status string `json:"status"`
+}
+
+// setPresence stores the status of the client's connection and tells the
+// user's chat partners if the status of the user has changed.
+func (server *WsServer) setPresence(client *Client, status string) error {
+	userStatus, changed, err := server.presenceService.SetStatus(
+		ctx, client.GetUserID(), client.GetID(), status)
+	if err != nil {
+		return err
+	}
+
+	client.status.Store(status)
+
+	if changed {
+		server.publishPresence(client.GetUserID(), userStatus)
+	}
+
+	return nil
+}
+
+func (server *WsServer) removePresence(client *Client) {
+	userStatus, changed, err := server.presenceService.Disconnect(
+		ctx, client.GetUserID(), client.GetID())
+	if err != nil {
+		log.Println("{presence}", err)
+		return
+	}
+
+	if changed {
+		server.publishPresence(client.GetUserID(), userStatus)
+	}
+}
+
+// heartbeat keeps the connections of this node alive and reports the users
+// whose connections timed out, e.g. because their node went down.
+func (server *WsServer) heartbeat(clients []*Client) {
+	beats := make([]presence.Heartbeat, 0, len(clients))
+	for _, client := range clients {
+		beats = append(beats, presence.Heartbeat{
+			UserID: client.GetUserID(),
+			ConnID: client.GetID(),
+			Status: client.GetStatus(),
+		})
+	}
+
+	if err := server.presenceService.Heartbeat(ctx, beats); err != nil {
+		log.Println("{presence}", err)
+	}
+
+	expired, err := server.presenceService.ExpiredUsers(ctx)
+	if err != nil {
+		log.Println("{presence}", err)
+		return
+	}
+
+	for _, userID := range expired {
+		server.publishPresence(userID, presence.StatusOffline)
+	}
+}
+
+// publishPresence sends the status change to the users that share a chat
+// with the user.
+func (server *WsServer) publishPresence(userID int, status string) {
+	watchers, err := server.presenceService.GetWatchers(ctx, userID)
+	if err != nil {
+		log.Println("{presence}", err)
+		return
+	}
+
+	message := &WebsocketMessage{
+		Action: PresenceChangedAction,
+		Data: presenceData{
+			UserID: userID,
+			Status: status,
+		},
+	}
+
+	for _, watcherID := range watchers {
+		server.publishToUser(watcherID, message)
+	}
+}
+
+func (client *Client) handleSetPresenceMessage(message WebsocketMessage) error {
+	var request presenceRequest
+	if err := message.decodeData(&request); err != nil {
+		return errBadRequest
+	}
+
+	return client.wsServer.setPresence(client, request.Status)
+}
+
+func (client *Client) handleGetOnlineUsersMessage(message WebsocketMessage) ([]models.User, error) {
+	return client.wsServer.presenceService.GetOnlineUsers(ctx, client.GetUserID())
+}
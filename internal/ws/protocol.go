@@ -0,0 +1,138 @@
+package ws
+
+import (
+	"chatie/internal/apperror"
+	"errors"
+	"log"
+)
+
+// ProtocolVersion is the version of the frames sent by the server. Requests
+// with a newer version are rejected, requests without one are treated as v1.
+const ProtocolVersion = 1
+
+// Error codes sent to the clients, they are part of the protocol and must
+// not change.
+const (
+	CodeBadRequest         = "bad-request"
+	CodeUnsupportedVersion = "unsupported-version"
+	CodeUnknownAction      = "unknown-action"
+	CodeNotAuthorized      = "not-authorized"
+	CodeForbidden          = "forbidden"
+	CodeNotMember          = "not-a-member"
+	CodeBanned             = "banned"
+	CodeMuted              = "muted"
+	CodeUserNotFound       = "user-not-found"
+	CodeChatNotFound       = "chat-not-found"
+	CodeChatDeleted        = "chat-deleted"
+	CodeMessageNotFound    = "message-not-found"
+	CodeInvalidMessage     = "invalid-message"
+	CodeInvalidChat        = "invalid-chat"
+	CodeInvalidReaction    = "invalid-reaction"
+	CodeInvalidPresence    = "invalid-presence"
+	CodeRateLimited        = "rate-limited"
+	CodeInternal           = "internal"
+)
+
+var (
+	errBadRequest         = errors.New("malformed request")
+	errUnsupportedVersion = errors.New("unsupported protocol version")
+	errUnknownAction      = errors.New("unknown action")
+	errRateLimited        = errors.New("too many requests")
+)
+
+type ProtocolError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// newProtocolError maps the error to its code. Errors the clients don't know
+// about are reported as internal without their details.
+func newProtocolError(err error) *ProtocolError {
+	code := errorCode(err)
+	if code == CodeInternal {
+		return &ProtocolError{Code: code, Message: apperror.ErrInternal.Error()}
+	}
+
+	return &ProtocolError{Code: code, Message: err.Error()}
+}
+
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, errBadRequest):
+		return CodeBadRequest
+	case errors.Is(err, errUnsupportedVersion):
+		return CodeUnsupportedVersion
+	case errors.Is(err, errUnknownAction):
+		return CodeUnknownAction
+	case errors.Is(err, errRateLimited):
+		return CodeRateLimited
+	case errors.Is(err, apperror.ErrNotAuthorized):
+		return CodeNotAuthorized
+	case errors.Is(err, apperror.ErrForbidden):
+		return CodeForbidden
+	case errors.Is(err, apperror.ErrChatMemberNotFound):
+		return CodeNotMember
+	case errors.Is(err, apperror.ErrChatMemberBanned):
+		return CodeBanned
+	case errors.Is(err, apperror.ErrChatMemberMuted):
+		return CodeMuted
+	case errors.Is(err, apperror.ErrModerationInvalid):
+		return CodeBadRequest
+	case errors.Is(err, apperror.ErrUserNotFound):
+		return CodeUserNotFound
+	case errors.Is(err, apperror.ErrChatNotFound):
+		return CodeChatNotFound
+	case errors.Is(err, apperror.ErrChatDeleted):
+		return CodeChatDeleted
+	case errors.Is(err, apperror.ErrMessageNotFound):
+		return CodeMessageNotFound
+	case errors.Is(err, apperror.ErrMessageInvalid):
+		return CodeInvalidMessage
+	case errors.Is(err, apperror.ErrChatInvalid):
+		return CodeInvalidChat
+	case errors.Is(err, apperror.ErrReactionInvalid):
+		return CodeInvalidReaction
+	case errors.Is(err, apperror.ErrPresenceInvalid):
+		return CodeInvalidPresence
+	default:
+		return CodeInternal
+	}
+}
+
+// sendAck answers the request with the result of its action.
+func (client *Client) sendAck(request WebsocketMessage, result any) {
+	ack := &WebsocketMessage{
+		Action:  AckAction,
+		ReplyTo: request.RequestID,
+		Target:  request.Target,
+		Data:    result,
+	}
+
+	client.enqueue(ack)
+}
+
+// sendError answers the request with the reason it failed.
+func (client *Client) sendError(request WebsocketMessage, err error) {
+	protocolErr := newProtocolError(err)
+	if protocolErr.Code == CodeInternal {
+		log.Printf("{%s} %s", request.Action, err)
+	}
+
+	reply := &WebsocketMessage{
+		Action:  ErrorAction,
+		ReplyTo: request.RequestID,
+		Target:  request.Target,
+		Error:   protocolErr,
+	}
+
+	client.enqueue(reply)
+}
+
+// newBatch wraps the frames queued for a client into a single batch frame.
+func newBatch(frames []WebsocketMessage) *WebsocketMessage {
+	return &WebsocketMessage{
+		Version: ProtocolVersion,
+		Action:  BatchAction,
+		Data:    frames,
+	}
+}
@@ -0,0 +1,161 @@
+package ws
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const rateLimitReason = "rate limit exceeded"
+
+// Actions limited by the bucket of their target chat
+var chatLimitedActions = map[string]bool{
+	SendMessageAction:    true,
+	EditMessageAction:    true,
+	DeleteMessageAction:  true,
+	AddReactionAction:    true,
+	RemoveReactionAction: true,
+}
+
+// tokenBucket holds up to burst tokens refilled at rate tokens per second. A
+// nil bucket allows everything.
+type tokenBucket struct {
+	rate     float64
+	burst    float64
+	tokens   float64
+	lastSeen time.Time
+	lock     sync.Mutex
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+
+	return &tokenBucket{
+		rate:     rate,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		lastSeen: time.Now(),
+	}
+}
+
+func (b *tokenBucket) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.lastSeen).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// full reports whether the bucket has refilled, such buckets can be dropped
+// and made again on demand.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.tokens+now.Sub(b.lastSeen).Seconds()*b.rate >= b.burst
+}
+
+// bucketSet keeps a token bucket for every user or chat.
+type bucketSet struct {
+	rate    float64
+	burst   int
+	buckets map[int]*tokenBucket
+	lock    sync.Mutex
+}
+
+func newBucketSet(rate float64, burst int) *bucketSet {
+	return &bucketSet{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[int]*tokenBucket),
+	}
+}
+
+func (s *bucketSet) allow(key int) bool {
+	if s.rate <= 0 {
+		return true
+	}
+
+	s.lock.Lock()
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = newTokenBucket(s.rate, s.burst)
+		s.buckets[key] = bucket
+	}
+	s.lock.Unlock()
+
+	return bucket.allow()
+}
+
+// sweep drops the buckets that have refilled.
+func (s *bucketSet) sweep() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	for key, bucket := range s.buckets {
+		if bucket.full(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// checkRateLimit takes a token for the request from the buckets of the
+// connection, the user and the target chat. The chat bucket is only charged
+// for chats the client has joined, so outsiders can't drain it and lock the
+// members out. Clients that keep going over the limits are disconnected.
+func (client *Client) checkRateLimit(message WebsocketMessage) error {
+	server := client.wsServer
+	allowed := client.limiter.allow() && server.userLimits.allow(client.GetUserID())
+	if allowed && chatLimitedActions[message.Action] {
+		if chat := client.findJoinedChat(message.Target); chat != nil {
+			allowed = server.chatLimits.allow(chat.GetChatID())
+		}
+	}
+
+	if allowed {
+		return nil
+	}
+
+	if client.addViolation() {
+		client.close(websocket.ClosePolicyViolation, rateLimitReason)
+	}
+
+	return errRateLimited
+}
+
+// addViolation counts a limited request and reports whether the client has
+// gone over the limits too often. It is only called by the read pump.
+func (client *Client) addViolation() bool {
+	limits := client.wsServer.options.RateLimits
+	if limits.MaxViolations <= 0 {
+		return false
+	}
+
+	now := time.Now()
+	if now.Sub(client.violationsSince) > limits.ViolationWindow {
+		client.violations = 0
+		client.violationsSince = now
+	}
+	client.violations++
+
+	return client.violations > limits.MaxViolations
+}
@@ -0,0 +1,199 @@
+package registry
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// DefaultShards is enough to keep lock contention low with 100k+ keys.
+const DefaultShards = 64
+
+// Index maps keys to sets of values. The keys are spread over shards with
+// their own locks, so updates and lookups of different keys rarely wait for
+// each other.
+type Index[K comparable, V comparable] struct {
+	shards []shard[K, V]
+	hash   func(K) uint64
+}
+
+type shard[K comparable, V comparable] struct {
+	sync.RWMutex
+	items map[K]map[V]struct{}
+}
+
+func NewIndex[K comparable, V comparable](shards int, hash func(K) uint64) *Index[K, V] {
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+
+	index := &Index[K, V]{
+		shards: make([]shard[K, V], shards),
+		hash:   hash,
+	}
+	for i := range index.shards {
+		index.shards[i].items = make(map[K]map[V]struct{})
+	}
+
+	return index
+}
+
+func (i *Index[K, V]) shard(key K) *shard[K, V] {
+	return &i.shards[i.hash(key)%uint64(len(i.shards))]
+}
+
+// Add adds the value to the set of the key and reports whether it is the
+// first value of the key.
+func (i *Index[K, V]) Add(key K, value V) bool {
+	s := i.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	values, ok := s.items[key]
+	if !ok {
+		values = make(map[V]struct{})
+		s.items[key] = values
+	}
+	values[value] = struct{}{}
+
+	return !ok
+}
+
+// Remove removes the value from the set of the key. It reports whether the
+// value was there and whether it was the last value of the key.
+func (i *Index[K, V]) Remove(key K, value V) (bool, bool) {
+	s := i.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	values, ok := s.items[key]
+	if !ok {
+		return false, false
+	}
+	if _, ok := values[value]; !ok {
+		return false, false
+	}
+
+	delete(values, value)
+	if len(values) == 0 {
+		delete(s.items, key)
+		return true, true
+	}
+
+	return true, false
+}
+
+// Has reports whether the key has any value.
+func (i *Index[K, V]) Has(key K) bool {
+	s := i.shard(key)
+	s.RLock()
+	defer s.RUnlock()
+
+	return len(s.items[key]) > 0
+}
+
+// Get returns the values of the key.
+func (i *Index[K, V]) Get(key K) []V {
+	s := i.shard(key)
+	s.RLock()
+	defer s.RUnlock()
+
+	values := make([]V, 0, len(s.items[key]))
+	for value := range s.items[key] {
+		values = append(values, value)
+	}
+
+	return values
+}
+
+// First returns any value of the key.
+func (i *Index[K, V]) First(key K) (V, bool) {
+	s := i.shard(key)
+	s.RLock()
+	defer s.RUnlock()
+
+	for value := range s.items[key] {
+		return value, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// GetOrAdd returns a value of the key, adding the one made by create if the
+// key has none. It returns whether the value was created.
+func (i *Index[K, V]) GetOrAdd(key K, create func() V) (V, bool) {
+	s := i.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	for value := range s.items[key] {
+		return value, false
+	}
+
+	value := create()
+	s.items[key] = map[V]struct{}{value: {}}
+
+	return value, true
+}
+
+// Range calls fn for every value until it returns false. Shards are visited
+// one at a time, so the values added or removed meanwhile may be missed.
+func (i *Index[K, V]) Range(fn func(key K, value V) bool) {
+	for n := range i.shards {
+		s := &i.shards[n]
+
+		s.RLock()
+		for key, values := range s.items {
+			for value := range values {
+				if !fn(key, value) {
+					s.RUnlock()
+					return
+				}
+			}
+		}
+		s.RUnlock()
+	}
+}
+
+// Values returns every value of the index.
+func (i *Index[K, V]) Values() []V {
+	var values []V
+	i.Range(func(_ K, value V) bool {
+		values = append(values, value)
+		return true
+	})
+
+	return values
+}
+
+// Len returns the number of keys.
+func (i *Index[K, V]) Len() int {
+	n := 0
+	for k := range i.shards {
+		s := &i.shards[k]
+		s.RLock()
+		n += len(s.items)
+		s.RUnlock()
+	}
+
+	return n
+}
+
+func IntHash(key int) uint64 {
+	// finalizer of murmur3, spreads sequential ids over the shards
+	x := uint64(key)
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	return x
+}
+
+func StringHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	return h.Sum64()
+}
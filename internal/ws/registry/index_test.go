@@ -0,0 +1,241 @@
+package registry
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Number of connections registered before each benchmark, the scale the
+// registry of a node is meant for
+const benchConnections = 100000
+
+type benchConn struct {
+	id     string
+	userID int
+}
+
+func newBenchIndexes(n int) (*Index[string, *benchConn], *Index[int, *benchConn], []*benchConn) {
+	byID := NewIndex[string, *benchConn](DefaultShards, StringHash)
+	byUser := NewIndex[int, *benchConn](DefaultShards, IntHash)
+
+	conns := make([]*benchConn, n)
+	for i := range conns {
+		conn := &benchConn{id: "conn-" + strconv.Itoa(i), userID: i / 2}
+		conns[i] = conn
+		byID.Add(conn.id, conn)
+		byUser.Add(conn.userID, conn)
+	}
+
+	return byID, byUser, conns
+}
+
+// picker returns the registered connections in a spread out order, safe for
+// concurrent use.
+func picker(conns []*benchConn) func() *benchConn {
+	var next atomic.Uint64
+	return func() *benchConn {
+		return conns[next.Add(7919)%uint64(len(conns))]
+	}
+}
+
+func BenchmarkIndexAddRemove(b *testing.B) {
+	byID, byUser, _ := newBenchIndexes(benchConnections)
+
+	var n atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := int(n.Add(1))
+			conn := &benchConn{id: "extra-" + strconv.Itoa(i), userID: -i}
+			byID.Add(conn.id, conn)
+			byUser.Add(conn.userID, conn)
+			byID.Remove(conn.id, conn)
+			byUser.Remove(conn.userID, conn)
+		}
+	})
+}
+
+func BenchmarkIndexFirst(b *testing.B) {
+	byID, _, conns := newBenchIndexes(benchConnections)
+	pick := picker(conns)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			byID.First(pick().id)
+		}
+	})
+}
+
+func BenchmarkIndexGet(b *testing.B) {
+	_, byUser, conns := newBenchIndexes(benchConnections)
+	pick := picker(conns)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			byUser.Get(pick().userID)
+		}
+	})
+}
+
+// BenchmarkIndexMixed looks connections up while others come and go, as
+// fan-out does while clients connect and disconnect.
+func BenchmarkIndexMixed(b *testing.B) {
+	byID, byUser, conns := newBenchIndexes(benchConnections)
+	pick := picker(conns)
+
+	var n atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := int(n.Add(1))
+			if i%10 == 0 {
+				conn := &benchConn{id: "extra-" + strconv.Itoa(i), userID: -i}
+				byID.Add(conn.id, conn)
+				byUser.Add(conn.userID, conn)
+				byID.Remove(conn.id, conn)
+				byUser.Remove(conn.userID, conn)
+				continue
+			}
+			byUser.Get(pick().userID)
+		}
+	})
+}
+
+// hubRegistry is the registry the Index replaced, kept as the baseline:
+// registration goes through the channels of a single hub goroutine and
+// lookups scan every connection.
+type hubRegistry struct {
+	clients    map[*benchConn]bool
+	register   chan *benchConn
+	unregister chan *benchConn
+	done       chan struct{}
+	lock       sync.RWMutex
+}
+
+func newBenchHub(b *testing.B, conns []*benchConn) *hubRegistry {
+	hub := &hubRegistry{
+		clients:    make(map[*benchConn]bool),
+		register:   make(chan *benchConn),
+		unregister: make(chan *benchConn),
+		done:       make(chan struct{}),
+	}
+	for _, conn := range conns {
+		hub.clients[conn] = true
+	}
+	go hub.run()
+	b.Cleanup(func() { close(hub.done) })
+
+	return hub
+}
+
+func (h *hubRegistry) run() {
+	for {
+		select {
+		case conn := <-h.register:
+			h.lock.Lock()
+			h.clients[conn] = true
+			h.lock.Unlock()
+		case conn := <-h.unregister:
+			h.lock.Lock()
+			delete(h.clients, conn)
+			h.lock.Unlock()
+		case <-h.done:
+			return
+		}
+	}
+}
+
+func (h *hubRegistry) findByID(id string) *benchConn {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	for conn := range h.clients {
+		if conn.id == id {
+			return conn
+		}
+	}
+
+	return nil
+}
+
+func (h *hubRegistry) findByUser(userID int) []*benchConn {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	var conns []*benchConn
+	for conn := range h.clients {
+		if conn.userID == userID {
+			conns = append(conns, conn)
+		}
+	}
+
+	return conns
+}
+
+func BenchmarkHubAddRemove(b *testing.B) {
+	_, _, conns := newBenchIndexes(benchConnections)
+	hub := newBenchHub(b, conns)
+
+	var n atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := int(n.Add(1))
+			conn := &benchConn{id: "extra-" + strconv.Itoa(i), userID: -i}
+			hub.register <- conn
+			hub.unregister <- conn
+		}
+	})
+}
+
+func BenchmarkHubFirst(b *testing.B) {
+	_, _, conns := newBenchIndexes(benchConnections)
+	hub := newBenchHub(b, conns)
+	pick := picker(conns)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			hub.findByID(pick().id)
+		}
+	})
+}
+
+func BenchmarkHubGet(b *testing.B) {
+	_, _, conns := newBenchIndexes(benchConnections)
+	hub := newBenchHub(b, conns)
+	pick := picker(conns)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			hub.findByUser(pick().userID)
+		}
+	})
+}
+
+// BenchmarkHubMixed is BenchmarkIndexMixed against the hub.
+func BenchmarkHubMixed(b *testing.B) {
+	_, _, conns := newBenchIndexes(benchConnections)
+	hub := newBenchHub(b, conns)
+	pick := picker(conns)
+
+	var n atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := int(n.Add(1))
+			if i%10 == 0 {
+				conn := &benchConn{id: "extra-" + strconv.Itoa(i), userID: -i}
+				hub.register <- conn
+				hub.unregister <- conn
+				continue
+			}
+			hub.findByUser(pick().userID)
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package ws
+
+import (
+	"chatie/internal/apperror"
+	"chatie/internal/models"
+	"log"
+	"strconv"
+)
+
+// Max number of missed messages replayed on resume, clients that missed more
+// are told to refetch the history instead
+const maxResumeMessages = 200
+
+// resumeReplay is what a resuming client missed in a chat, loaded off the
+// room goroutine
+type resumeReplay struct {
+	client     *Client
+	lastSeenID int
+	messages   []models.Message
+	resync     bool // too many messages were missed or they couldn't be loaded
+}
+
+// heldFrames are the live frames of a resuming client, held back until its
+// replay has been sent
+type heldFrames struct {
+	frames   []*WebsocketMessage
+	overflow bool
+}
+
+func (h *heldFrames) add(message *WebsocketMessage) {
+	if h.overflow {
+		return
+	}
+	if len(h.frames) >= maxResumeMessages {
+		h.frames = nil
+		h.overflow = true
+		return
+	}
+	h.frames = append(h.frames, message)
+}
+
+type resumeData struct {
+	Chats map[string]int `json:"chats"` // chat id -> last seen message id
+}
+
+type resumeResult struct {
+	Failed map[string]*ProtocolError `json:"failed,omitempty"` // chat id -> error
+}
+
+type resumedData struct {
+	LastSeenID int `json:"lastSeenID"`
+	Replayed   int `json:"replayed"`
+}
+
+// handleResumeMessage rejoins the chats of a reconnected client, replaying
+// the messages it missed in each of them before the live ones. The chats that
+// can't be rejoined are reported in the result.
+func (client *Client) handleResumeMessage(message WebsocketMessage) (*resumeResult, error) {
+	var request resumeData
+	if err := message.decodeData(&request); err != nil {
+		return nil, errBadRequest
+	}
+
+	result := &resumeResult{Failed: map[string]*ProtocolError{}}
+	for target, lastSeenID := range request.Chats {
+		chatID, err := strconv.Atoi(target)
+		if err != nil {
+			result.Failed[target] = newProtocolError(apperror.ErrChatNotFound)
+			continue
+		}
+
+		chat, err := client.wsServer.authorizeChatMember(chatID, client.GetUserID())
+		if err != nil {
+			result.Failed[target] = newProtocolError(err)
+			continue
+		}
+
+		wsChat := client.wsServer.findOrCreateChat(chat)
+		if client.isInChat(wsChat) {
+			continue
+		}
+
+		if !wsChat.requestResume(client) {
+			result.Failed[target] = newProtocolError(apperror.ErrInternal)
+			continue
+		}
+		if !wsChat.completeResume(client.loadReplay(wsChat, lastSeenID)) {
+			result.Failed[target] = newProtocolError(apperror.ErrInternal)
+			continue
+		}
+		client.wsChats[wsChat] = true
+	}
+
+	return result, nil
+}
+
+// enterPersistedChatAfter joins the room of the chat like enterPersistedChat,
+// but replays the messages stored after lastSeenID first, so a message fanned
+// out just before the join isn't missed.
+func (client *Client) enterPersistedChatAfter(chat *models.Chat, lastSeenID int) error {
+	for attempt := 0; attempt < 2; attempt++ {
+		wsChat := client.wsServer.findOrCreateChat(chat)
+		if client.isInChat(wsChat) {
+			return nil
+		}
+
+		if !wsChat.requestResume(client) {
+			continue
+		}
+		client.notifyChatJoined(wsChat)
+		if wsChat.completeResume(client.loadReplay(wsChat, lastSeenID)) {
+			client.wsChats[wsChat] = true
+			return nil
+		}
+	}
+
+	return apperror.ErrInternal
+}
+
+// loadReplay loads the messages stored in the chat after the last one the
+// client has seen. The room holds the live frames for the client meanwhile,
+// so nothing is missed between the load and the registration.
+func (client *Client) loadReplay(chat *WsChat, lastSeenID int) *resumeReplay {
+	replay := &resumeReplay{
+		client:     client,
+		lastSeenID: lastSeenID,
+	}
+
+	messages, err := client.wsServer.messageService.GetMessagesAfter(
+		ctx, chat.GetChatID(), lastSeenID, maxResumeMessages+1)
+	if err != nil {
+		log.Printf("chat %s: can't replay messages: %s", chat.GetName(), err)
+	}
+
+	if err != nil || len(messages) > maxResumeMessages {
+		replay.resync = true
+		return replay
+	}
+	replay.messages = messages
+
+	return replay
+}
+
+// holdClient starts holding the live frames for a resuming client.
+func (c *WsChat) holdClient(client *Client) {
+	c.held[client] = &heldFrames{}
+}
+
+// resumeClient sends the replay to the client, then the live frames held for
+// it that weren't part of the replay, and registers it for the live ones.
+func (c *WsChat) resumeClient(replay *resumeReplay) {
+	client := replay.client
+
+	held, ok := c.held[client]
+	if !ok {
+		// the client left while its replay was loaded
+		return
+	}
+	delete(c.held, client)
+
+	if replay.resync || held.overflow {
+		resync := &WebsocketMessage{
+			Action: ResyncRequiredAction,
+			Target: c.GetName(),
+			Data: resumedData{
+				LastSeenID: replay.lastSeenID,
+			},
+		}
+		client.enqueue(resync)
+		c.sendHeld(client, held, replay.lastSeenID)
+		c.registerClientInChat(client)
+		return
+	}
+
+	floor := replay.lastSeenID
+	for i := range replay.messages {
+		replayed := &WebsocketMessage{
+			Action:  SendMessageAction,
+			Target:  c.GetName(),
+			Message: &replay.messages[i],
+		}
+		client.enqueue(replayed)
+		floor = replay.messages[i].ID
+	}
+
+	resumed := &WebsocketMessage{
+		Action: ResumedAction,
+		Target: c.GetName(),
+		Data: resumedData{
+			LastSeenID: floor,
+			Replayed:   len(replay.messages),
+		},
+	}
+	client.enqueue(resumed)
+
+	c.sendHeld(client, held, floor)
+	c.registerClientInChat(client)
+}
+
+// sendHeld sends the held frames to the client, skipping the messages up to
+// floor as they were already replayed from storage.
+func (c *WsChat) sendHeld(client *Client, held *heldFrames, floor int) {
+	for _, frame := range held.frames {
+		if messageID := chatMessageID(frame); messageID != 0 && messageID <= floor {
+			continue
+		}
+		client.enqueue(frame)
+	}
+}
+
+// chatMessageID returns the id of the chat message carried by the frame, or
+// 0 if the frame isn't a new message.
+func chatMessageID(message *WebsocketMessage) int {
+	if message.Action != SendMessageAction || message.Message == nil {
+		return 0
+	}
+
+	return message.Message.ID
+}
@@ -2,278 +2,415 @@ package ws
 
 import (
 	"chatie/internal/apperror"
+	"chatie/internal/broker"
 	"chatie/internal/models"
-	"chatie/internal/repository"
+	"chatie/internal/presence"
 	"chatie/internal/services"
+	"chatie/internal/ws/registry"
 	"context"
-	"encoding/json"
-	"errors"
+	"fmt"
 	"log"
-
-	"github.com/redis/go-redis/v9"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const PubSubGeneralChannel = "general"
-
 var ctx = context.Background()
 
+type MessageService interface {
+	SaveMessage(ctx context.Context, message *models.Message, chatID int, userID int) (*models.Message, error)
+	GetHistory(ctx context.Context, chatID int, userID int, before int, limit int) ([]models.Message, error)
+	GetMessagesAfter(ctx context.Context, chatID int, after int, limit int) ([]models.Message, error)
+	MarkRead(ctx context.Context, chatID int, userID int, messageID int) (*models.ReadReceipt, error)
+	EditMessage(ctx context.Context, chatID int, userID int, messageID int, text string) (*models.Message, error)
+	DeleteMessage(ctx context.Context, chatID int, userID int, messageID int) (*models.Message, error)
+	AddReaction(ctx context.Context, chatID int, userID int, messageID int, emoji string) (*models.ReactionsUpdate, error)
+	RemoveReaction(ctx context.Context, chatID int, userID int, messageID int, emoji string) (*models.ReactionsUpdate, error)
+	GetThread(ctx context.Context, chatID int, userID int, rootID int, after int, limit int) (*models.Thread, error)
+	GetThreadSummary(ctx context.Context, chatID int, rootID int) (*models.ThreadSummary, error)
+}
+
+type ChatService interface {
+	OpenDirectChat(ctx context.Context, userID int, peerID int) (*models.Chat, error)
+	Moderate(ctx context.Context, moderation models.Moderation) (*models.Moderation, error)
+}
+
+type MentionService interface {
+	CreateMentions(ctx context.Context, message *models.Message) ([]models.Mention, error)
+}
+
+type PresenceService interface {
+	TTL() time.Duration
+	SetStatus(ctx context.Context, userID int, connID string, status string) (string, bool, error)
+	Disconnect(ctx context.Context, userID int, connID string) (string, bool, error)
+	Heartbeat(ctx context.Context, beats []presence.Heartbeat) error
+	ExpiredUsers(ctx context.Context) ([]int, error)
+	GetWatchers(ctx context.Context, userID int) ([]int, error)
+	GetOnlineUsers(ctx context.Context, userID int) ([]models.User, error)
+}
+
 type WsServer struct {
-	clients    map[*Client]bool
-	register   chan *Client
-	unregister chan *Client
-	broadcast  chan []byte
-	wsChats    map[*WsChat]bool
-	users      map[*models.User]bool
+	// Indexes of the local connections and rooms. They are sharded, so
+	// clients register concurrently instead of going through Run.
+	clients     *registry.Index[string, *Client] // by connection id
+	userClients *registry.Index[int, *Client]    // by user id
+	chats       *registry.Index[int, *WsChat]    // by chat id
+	roomChats   *registry.Index[string, *WsChat] // by room id
 	// userService services.UserServices
-	chatRepository repository.ChatRepository
-	userRepository services.UserRepository
-	redis          *redis.Client
-	// sync.RWMutex
+	chatRepository    services.ChatRepository
+	userRepository    services.UserRepository
+	chatService       ChatService
+	messageService    MessageService
+	presenceService   PresenceService
+	mentionService    MentionService
+	broker            broker.Broker
+	codec             Codec // encoding of the broker payloads
+	options           Options
+	userSubscriptions map[int]broker.Subscription
+	subscriptionsLock sync.Mutex
+	userChannelLocks  [registry.DefaultShards]sync.Mutex // (un)subscriptions of the users, by user id hash
+	userLimits        *bucketSet
+	chatLimits        *bucketSet
+	shuttingDown      atomic.Bool
 }
 
 // NewWebsocketServer creates a new WsServer type
 func NewWsServer(
-	chatRepository repository.ChatRepository,
+	chatRepository services.ChatRepository,
 	userRepository services.UserRepository,
-	redis *redis.Client,
+	chatService ChatService,
+	messageService MessageService,
+	presenceService PresenceService,
+	mentionService MentionService,
+	messageBroker broker.Broker,
+	brokerCodec Codec,
+	options Options,
 ) *WsServer {
 	wsServer := &WsServer{
-		clients:        make(map[*Client]bool),
-		register:       make(chan *Client),
-		unregister:     make(chan *Client),
-		broadcast:      make(chan []byte),
-		wsChats:        make(map[*WsChat]bool),
-		users:          make(map[*models.User]bool),
-		chatRepository: chatRepository,
-		userRepository: userRepository,
-		redis:          redis,
+		clients:           registry.NewIndex[string, *Client](registry.DefaultShards, registry.StringHash),
+		userClients:       registry.NewIndex[int, *Client](registry.DefaultShards, registry.IntHash),
+		chats:             registry.NewIndex[int, *WsChat](registry.DefaultShards, registry.IntHash),
+		roomChats:         registry.NewIndex[string, *WsChat](registry.DefaultShards, registry.StringHash),
+		chatRepository:    chatRepository,
+		userRepository:    userRepository,
+		chatService:       chatService,
+		messageService:    messageService,
+		presenceService:   presenceService,
+		mentionService:    mentionService,
+		broker:            messageBroker,
+		codec:             brokerCodec,
+		options:           options,
+		userSubscriptions: make(map[int]broker.Subscription),
+		userLimits:        newBucketSet(options.RateLimits.UserRate, options.RateLimits.UserBurst),
+		chatLimits:        newBucketSet(options.RateLimits.ChatRate, options.RateLimits.ChatBurst),
 	}
 
-	var err error
-	users, err := userRepository.GetAll(context.Background())
-	if err != nil {
-		if errors.Is(err, apperror.ErrUserNotFound) {
-			log.Println("", err)
-		} else {
-			log.Println("can't extract users from database: ", err)
-		}
-	}
-
-	for i := 0; i < len(users); i++ {
-		wsServer.users[&users[i]] = true
-	}
-
-	// for k, _ := range wsServer.users {
-	// 	log.Println("{wsServer}", k.ID, k.Email, k.Name)
-	// }
-
 	return wsServer
 }
 
-func (server *WsServer) Run() {
-	go server.listenPubSubChannel()
+// Run keeps the presence of the local clients alive until runCtx is done.
+func (server *WsServer) Run(runCtx context.Context) {
+	heartbeat := time.NewTicker(server.presenceService.TTL() / 3)
+	defer heartbeat.Stop()
+
+	var statsTick <-chan time.Time
+	if interval := server.options.StatsInterval; interval > 0 {
+		statsTicker := time.NewTicker(interval)
+		defer statsTicker.Stop()
+		statsTick = statsTicker.C
+	}
+
 	for {
 		select {
-		case client := <-server.register:
-			server.registerClient(client)
-		case client := <-server.unregister:
-			server.unregisterClient(client)
-			// case message := <-server.broadcast:
-			// 	server.broadcastToClients(message)
+		case <-runCtx.Done():
+			return
+		case <-heartbeat.C:
+			go server.heartbeat(server.localClients())
+			server.userLimits.sweep()
+			server.chatLimits.sweep()
+		case <-statsTick:
+			server.logQueueStats()
 		}
 	}
 }
 
-func (server *WsServer) publishClientJoined(client *Client) {
-	message := &WebsocketMessage{
-		Action: UserJoinedAction,
-		Sender: clientToUser(client),
-	}
+func (server *WsServer) registerClient(client *Client) {
+	userID := client.GetUserID()
 
-	if err := server.redis.Publish(ctx, PubSubGeneralChannel, message.encode()).Err(); err != nil {
-		// log.Println(err)
-		// return
+	server.clients.Add(client.GetID(), client)
+	if server.userClients.Add(userID, client) {
+		server.syncUserChannel(userID)
 	}
 }
 
-func (server *WsServer) publishClientLeft(client *Client) {
-	message := &WebsocketMessage{
-		Action: UserLeftAction,
-		Sender: clientToUser(client),
+func (server *WsServer) unregisterClient(client *Client) {
+	if removed, _ := server.clients.Remove(client.GetID(), client); !removed {
+		return
 	}
 
-	if err := server.redis.Publish(ctx, PubSubGeneralChannel, message.encode()).Err(); err != nil {
-		// log.Println(err)
-		// return
+	userID := client.GetUserID()
+
+	if _, last := server.userClients.Remove(userID, client); last {
+		server.syncUserChannel(userID)
 	}
 }
 
-func (server *WsServer) listenPubSubChannel() {
-	pubsub := server.redis.Subscribe(ctx, PubSubGeneralChannel)
+// syncUserChannel subscribes to the channel of the user while they have
+// connections on this node and unsubscribes once they have none. It is called
+// after their first connection is added and after their last one is removed,
+// outside of the locks of the index: the subscription is a round trip to the
+// broker. The lock of the user makes the calls for them take turns, and each
+// call acts on the connections the user has by then.
+func (server *WsServer) syncUserChannel(userID int) {
+	lock := &server.userChannelLocks[registry.IntHash(userID)%uint64(len(server.userChannelLocks))]
+	lock.Lock()
+	defer lock.Unlock()
+
+	server.subscriptionsLock.Lock()
+	_, subscribed := server.userSubscriptions[userID]
+	server.subscriptionsLock.Unlock()
+
+	connected := server.userClients.Has(userID)
+	switch {
+	case connected && !subscribed:
+		server.subscribeToUserChannel(userID)
+	case !connected && subscribed:
+		server.unsubscribeFromUserChannel(userID)
+	}
+}
 
-	ch := pubsub.Channel()
+// subscribeToUserChannel starts delivering the events addressed to the user
+// to their connections on this node.
+func (server *WsServer) subscribeToUserChannel(userID int) {
+	subscription, err := server.broker.Subscribe(ctx, userChannel(userID))
+	if err != nil {
+		log.Printf("can't subscribe to the channel of user %v: %s", userID, err)
+		return
+	}
 
-	for msg := range ch {
-		var message WebsocketMessage
-		if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
-			log.Printf("Error on unmarshal JSON message %s", err)
-			return
+	server.subscriptionsLock.Lock()
+	server.userSubscriptions[userID] = subscription
+	server.subscriptionsLock.Unlock()
+
+	go func() {
+		for payload := range subscription.Channel() {
+			message, err := server.decodePayload(payload)
+			if err != nil {
+				log.Printf("Error on decoding message %s", err)
+				continue
+			}
+			server.deliverToUser(userID, message)
 		}
+	}()
+}
 
-		switch message.Action {
-		case UserJoinedAction:
-			server.handleUserJoined(message)
-		case UserLeftAction:
-			server.handleUserLeft(message)
-		case JoinChatPrivateAction:
-			server.handleUserJoinPrivate(message)
-		}
+func (server *WsServer) unsubscribeFromUserChannel(userID int) {
+	server.subscriptionsLock.Lock()
+	subscription, ok := server.userSubscriptions[userID]
+	delete(server.userSubscriptions, userID)
+	server.subscriptionsLock.Unlock()
+
+	if ok {
+		subscription.Unsubscribe()
 	}
 }
 
-func (server *WsServer) handleUserJoined(message WebsocketMessage) {
-	// Add the user to the slice
-	if message.Sender != nil {
-		// server.users = append(server.users, *message.Sender)
-		server.users[message.Sender] = true
+// publishToUser delivers the message to every connection of the user on any
+// node.
+func (server *WsServer) publishToUser(userID int, message *WebsocketMessage) {
+	server.publish(userChannel(userID), message)
+}
+
+// publish sends the message to the subscribers of the channel on every node.
+func (server *WsServer) publish(channel string, message *WebsocketMessage) {
+	payload, err := server.encodePayload(message)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := server.broker.Publish(ctx, channel, payload); err != nil {
+		log.Println(err)
 	}
-	// server.users = append(server.users, *message.Sender)
-	server.broadcastToClients(message.encode())
 }
 
-func (server *WsServer) handleUserLeft(message WebsocketMessage) {
-	// for i, user := range server.users {
-	// 	if message.Sender != nil && user.GetID() == message.Sender.GetID() {
-	// 		server.users[i] = server.users[len(server.users)-1]
-	// 		server.users = server.users[:len(server.users)-1]
-	// 	}
-	// }
-	server.users[message.Sender] = false
+func (server *WsServer) encodePayload(message *WebsocketMessage) ([]byte, error) {
+	message.Version = ProtocolVersion
 
-	server.broadcastToClients(message.encode())
+	return server.codec.Marshal(message)
 }
 
-func (server *WsServer) handleUserJoinPrivate(message WebsocketMessage) {
-	targetClient := server.findClientByName(message.Target)
-	if targetClient != nil {
-		client := server.findClientByName(message.Sender.Name)
-		targetClient.joinChat(message.Target, client)
+func (server *WsServer) decodePayload(payload []byte) (*WebsocketMessage, error) {
+	var message WebsocketMessage
+	if err := server.codec.Unmarshal(payload, &message); err != nil {
+		return nil, err
 	}
-}
 
-func (server *WsServer) registerClient(client *Client) {
-	// server.userRepository.Create(ctx, clientToUser(client))
-	// server.notifyClientJoined(client)
-	// Publish user in PubSub
-	server.publishClientJoined(client)
-	// server.listOnlineClients(client)
-	server.clients[client] = true
+	return &message, nil
 }
 
-func (server *WsServer) unregisterClient(client *Client) {
-	if _, ok := server.clients[client]; ok {
-		delete(server.clients, client)
-		// server.notifyClientLeft(client)
-		// server.publishClientLeft(client)
+// deliverToUser handles an event published to the user: chat invites make the
+// local connections of the user join the chat, removals and deletions make
+// them leave it. Anything but invites is then sent to them.
+func (server *WsServer) deliverToUser(userID int, message *WebsocketMessage) {
+	switch message.Action {
+	case ChatInviteAction:
+		server.joinInvitedChat(userID, message.Target, message.Message)
+		return
+	case RemovedFromChatAction, ChatDeletedAction:
+		server.leaveRemovedChat(userID, message.Target)
 	}
+
+	server.sendToUser(userID, message)
 }
 
-func (server *WsServer) notifyClientJoined(client *Client) {
-	message := &WebsocketMessage{
-		Action: UserJoinedAction,
-		Sender: clientToUser(client),
+func (server *WsServer) sendToUser(userID int, message *WebsocketMessage) {
+	for _, client := range server.userClients.Get(userID) {
+		client.enqueue(message)
 	}
+}
 
-	server.broadcastToClients(message.encode())
+func (server *WsServer) localClients() []*Client {
+	return server.clients.Values()
 }
 
-func (server *WsServer) notifyClientLeft(client *Client) {
+// NotifyChat delivers an event to the members of the chat connected to any
+// node. Messages are sent in the message field of the frame, like new
+// messages are.
+func (server *WsServer) NotifyChat(chatID int, action string, data any) {
 	message := &WebsocketMessage{
-		Action: UserLeftAction,
-		Sender: clientToUser(client),
+		Action: action,
+		Target: chatChannel(chatID),
 	}
 
-	server.broadcastToClients(message.encode())
+	if chatMessage, ok := data.(*models.Message); ok {
+		message.Message = chatMessage
+	} else {
+		message.Data = data
+	}
+
+	server.publish(chatChannel(chatID), message)
 }
 
-func (server *WsServer) listOnlineClients(client *Client) {
-	for existingClient := range server.clients {
-		message := &WebsocketMessage{
-			Action: UserJoinedAction,
-			Sender: clientToUser(existingClient),
-		}
-		client.send <- message.encode()
+// notifyMentions stores the mentions of a new message and tells the
+// mentioned users, whether they are in the chat or not.
+func (server *WsServer) notifyMentions(message *models.Message) {
+	mentions, err := server.mentionService.CreateMentions(ctx, message)
+	if err != nil {
+		return
 	}
-}
 
-func (server *WsServer) broadcastToClients(message []byte) {
-	for client := range server.clients {
-		client.send <- message
+	for i := range mentions {
+		server.publishToUser(mentions[i].MentionedUserID, &WebsocketMessage{
+			Action: MentionedAction,
+			Target: chatChannel(message.ChatID),
+			Data:   &mentions[i],
+		})
 	}
 }
 
-func (server *WsServer) findChatByName(name string) *WsChat {
-	var chat *WsChat
-	for c := range server.wsChats {
-		if c.GetName() == name {
-			chat = c
-			break
-		}
+// notifyThread updates the summary of the thread of a new reply in the chat and
+// tells the participants of the thread that are still members about the reply.
+func (server *WsServer) notifyThread(message *models.Message) {
+	summary, err := server.messageService.GetThreadSummary(ctx, message.ChatID, message.ThreadID)
+	if err != nil {
+		return
 	}
 
-	return chat
-}
+	server.NotifyChat(message.ChatID, ThreadUpdatedAction, summary)
+
+	for _, userID := range summary.ParticipantIDs {
+		if userID == message.FromID {
+			continue
+		}
 
-func (server *WsServer) findRoomByID(ID string) *WsChat {
-	var chat *WsChat
-	for c := range server.wsChats {
-		if c.GetID() == ID {
-			chat = c
-			break
+		member, err := server.chatRepository.GetChatMemberByID(ctx, message.ChatID, userID)
+		if err != nil || member.IsBanned {
+			continue
 		}
+
+		server.publishToUser(userID, &WebsocketMessage{
+			Action:  ThreadRepliedAction,
+			Target:  chatChannel(message.ChatID),
+			Message: message,
+			Data:    summary,
+		})
 	}
+}
 
-	return chat
+func chatChannel(chatID int) string {
+	return strconv.Itoa(chatID)
 }
 
-func (server *WsServer) createChat(name string, private bool) *WsChat {
-	chat := NewChat(server, name, private)
-	go chat.Run()
-	server.wsChats[chat] = true
+func userChannel(userID int) string {
+	return fmt.Sprintf("user:%v", userID)
+}
 
+func (server *WsServer) findChatByID(chatID int) *WsChat {
+	chat, _ := server.chats.First(chatID)
 	return chat
 }
 
-func (server *WsServer) findClientByID(ID string) *Client {
-	var foundClient *Client
-	for client := range server.clients {
-		if client.GetID() == ID {
-			foundClient = client
-			break
-		}
+// findOrCreateChat returns the live room of a persisted chat, starting it on
+// first use. Rooms are named after the chat id, which is also their pub/sub
+// channel.
+func (server *WsServer) findOrCreateChat(chat *models.Chat) *WsChat {
+	wsChat, created := server.chats.GetOrAdd(chat.ID, func() *WsChat {
+		wsChat := NewChat(server, chat.ID, chatChannel(chat.ID), chat.Private)
+		wsChat.directUserIDs = chat.DirectUserIDs()
+		return wsChat
+	})
+
+	if created {
+		server.indexChat(wsChat)
+		go wsChat.Run()
 	}
 
-	return foundClient
+	return wsChat
 }
 
-func (server *WsServer) findClientByName(ID string) *Client {
-	var foundClient *Client
-	for client := range server.clients {
-		if client.GetName() == ID {
-			foundClient = client
-			break
-		}
+func (server *WsServer) indexChat(chat *WsChat) {
+	server.roomChats.Add(chat.GetID(), chat)
+}
+
+func (server *WsServer) removeChat(chat *WsChat) {
+	if chat.GetChatID() != 0 {
+		server.chats.Remove(chat.GetChatID(), chat)
+	}
+	server.roomChats.Remove(chat.GetID(), chat)
+}
+
+// authorizeChatMember checks that the chat exists and the user is allowed to
+// take part in it.
+func (server *WsServer) authorizeChatMember(chatID int, userID int) (*models.Chat, error) {
+	chat, err := server.chatRepository.GetByID(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	if chat.IsDeleted {
+		return nil, apperror.ErrChatDeleted
+	}
+
+	member, err := server.chatRepository.GetChatMemberByID(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if member.IsBanned {
+		return nil, apperror.ErrChatMemberBanned
 	}
 
-	return foundClient
+	return chat, nil
+}
+
+func (server *WsServer) findClientByID(ID string) *Client {
+	client, _ := server.clients.First(ID)
+	return client
 }
 
 func clientToUser(client *Client) *models.User {
-	user := &models.User{
-		// BaseModel: models.BaseModel{
-		// 	ID: client.GetID(),
-		// },
-		Name: client.GetName(),
-	}
-	return user
+	user := *client.user
+	return &user
 }
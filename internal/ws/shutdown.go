@@ -0,0 +1,66 @@
+package ws
+
+import (
+	"context"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const shutdownReason = "server is shutting down"
+
+// Period the clients and rooms are checked at while waiting for them to stop
+const shutdownPollPeriod = 50 * time.Millisecond
+
+// Shutdown stops accepting clients and closes the connected ones with 1001
+// once their queued frames are written. Then it stops the rooms, which
+// unsubscribes them from the broker, and the user channels. It returns the
+// error of ctx if it is done before everything is closed.
+func (server *WsServer) Shutdown(shutdownCtx context.Context) error {
+	server.shuttingDown.Store(true)
+
+	for _, client := range server.localClients() {
+		client.goAway(websocket.CloseGoingAway, shutdownReason)
+	}
+
+	err := waitUntil(shutdownCtx, func() bool {
+		return server.clients.Len() == 0
+	})
+
+	rooms := server.roomChats.Values()
+	for _, room := range rooms {
+		room.stop()
+	}
+
+	for _, room := range rooms {
+		select {
+		case <-room.done:
+		case <-shutdownCtx.Done():
+			err = shutdownCtx.Err()
+		}
+	}
+
+	server.subscriptionsLock.Lock()
+	for userID, subscription := range server.userSubscriptions {
+		subscription.Unsubscribe()
+		delete(server.userSubscriptions, userID)
+	}
+	server.subscriptionsLock.Unlock()
+
+	return err
+}
+
+func waitUntil(waitCtx context.Context, done func() bool) error {
+	ticker := time.NewTicker(shutdownPollPeriod)
+	defer ticker.Stop()
+
+	for !done() {
+		select {
+		case <-waitCtx.Done():
+			return waitCtx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
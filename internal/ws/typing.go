@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"chatie/internal/apperror"
+	"chatie/internal/models"
+	"time"
+)
+
+const (
+	// Time after which a typing notice expires if it isn't renewed
+	typingTimeout = 5 * time.Second
+
+	// Min interval between two typing notices of a user published to a chat,
+	// must be less than typing timeout
+	typingThrottle = 2 * time.Second
+
+	// How often expired typing notices are looked for
+	typingCheckPeriod = 1 * time.Second
+)
+
+type typingEvent struct {
+	user    *models.User
+	started bool
+}
+
+type typingData struct {
+	ExpiresIn int64 `json:"expiresIn"` // milliseconds
+}
+
+// typingState is what a chat remembers about a typing user. It outlives a
+// stop until the throttle has passed, so stopping and starting again can't
+// get around it.
+type typingState struct {
+	user        *models.User
+	shown       bool // a start was published and not followed by a stop yet
+	publishedAt time.Time
+	expiresAt   time.Time
+}
+
+// handleTyping relays typing notices to the chat. Starts are throttled and a
+// stop is only published after a start, so a noisy client can't flood the
+// broker, whether it repeats starts or alternates them with stops. Typing
+// notices are never persisted.
+func (c *WsChat) handleTyping(event *typingEvent) {
+	now := time.Now()
+	state, ok := c.typing[event.user.ID]
+
+	if !event.started {
+		if ok && state.shown {
+			state.shown = false
+			c.publishTyping(TypingStopAction, state.user)
+		}
+		return
+	}
+
+	if !ok {
+		state = &typingState{user: event.user}
+		c.typing[event.user.ID] = state
+	}
+	state.expiresAt = now.Add(typingTimeout)
+
+	if now.Sub(state.publishedAt) >= typingThrottle {
+		state.shown = true
+		state.publishedAt = now
+		c.publishTyping(TypingStartAction, state.user)
+	}
+}
+
+// expireTyping publishes stops for typing notices that weren't renewed and
+// forgets users once their throttle has passed.
+func (c *WsChat) expireTyping() {
+	now := time.Now()
+	for userID, state := range c.typing {
+		if state.shown && now.After(state.expiresAt) {
+			state.shown = false
+			c.publishTyping(TypingStopAction, state.user)
+		}
+		if !state.shown && now.Sub(state.publishedAt) >= typingThrottle {
+			delete(c.typing, userID)
+		}
+	}
+}
+
+func (c *WsChat) publishTyping(action string, user *models.User) {
+	message := &WebsocketMessage{
+		Action: action,
+		Target: c.GetName(),
+		Sender: user,
+	}
+
+	if action == TypingStartAction {
+		message.Data = typingData{
+			ExpiresIn: typingTimeout.Milliseconds(),
+		}
+	}
+
+	c.enqueueChatMessage(message)
+}
+
+// handleTypingMessage relays a typing notice of the client. Banned and muted
+// members can't start typing, stops are always let through.
+func (client *Client) handleTypingMessage(message WebsocketMessage) error {
+	chat := client.findJoinedChat(message.Target)
+	if chat == nil {
+		return apperror.ErrNotAuthorized
+	}
+
+	if message.Action == TypingStartAction {
+		member, err := client.wsServer.chatRepository.GetChatMemberByID(ctx, chat.GetChatID(), client.GetUserID())
+		if err != nil {
+			return err
+		}
+		if member.IsBanned {
+			return apperror.ErrChatMemberBanned
+		}
+		if member.IsMuted() {
+			return apperror.ErrChatMemberMuted
+		}
+	}
+
+	chat.sendTyping(&typingEvent{
+		user:    clientToUser(client),
+		started: message.Action == TypingStartAction,
+	})
+
+	return nil
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"chatie/internal/broker"
 	"chatie/internal/config"
 	"chatie/internal/handlers"
+	"chatie/internal/presence"
 	"chatie/internal/repository"
 	"chatie/internal/server"
 	"chatie/internal/services"
@@ -19,7 +21,6 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 )
 
@@ -60,18 +61,58 @@ func main() {
 	defer dbpool.Close()
 	logger.Debug("postgres connected")
 
-	opt, err := redis.ParseURL("redis://localhost:6364/0")
+	msgBroker, err := broker.NewBroker(cfg.Broker.Type, cfg.Broker.RedisURL)
 	if err != nil {
-		logger.Fatal(err)
+		logger.Fatal("broker connection failed: ", err)
 	}
-	redis := redis.NewClient(opt)
-	logger.Debug("redis connected", redis)
+	defer msgBroker.Close()
+	logger.Debug("broker connected: ", cfg.Broker.Type)
 
 	chatRepo := repository.NewChatRepository(dbpool)
 	userRepo := repository.NewUserRepository(dbpool)
+	messageRepo := repository.NewMessageRepository(dbpool)
+	mentionRepo := repository.NewMentionRepository(dbpool)
+	inviteRepo := repository.NewInviteRepository(dbpool)
+
+	chatService := services.NewChatService(chatRepo, userRepo, cfg.Chats.RestoreWindow)
+	messageService := services.NewMessageService(messageRepo, chatRepo)
+	presenceService := services.NewPresenceService(
+		presence.NewStore(msgBroker), chatRepo, userRepo, cfg.Presence.TTL)
+	mentionService := services.NewMentionService(mentionRepo, userRepo, chatRepo)
+	inviteService := services.NewInviteService(inviteRepo, chatRepo)
+
+	brokerCodec, err := ws.NewCodec(cfg.Broker.Codec)
+	if err != nil {
+		logger.Fatal("broker codec: ", err)
+	}
+
+	wsOptions := ws.Options{
+		SendQueueSize:      cfg.Websocket.SendQueueSize,
+		SlowConsumerPolicy: cfg.Websocket.SlowConsumerPolicy,
+		SlowConsumerCode:   cfg.Websocket.SlowConsumerCloseCode,
+		RoomIdleTimeout:    cfg.Websocket.RoomIdleTimeout,
+		StatsInterval:      cfg.Websocket.StatsInterval,
+		RateLimits: ws.RateLimits{
+			ConnectionRate:  cfg.RateLimit.ConnectionRate,
+			ConnectionBurst: cfg.RateLimit.ConnectionBurst,
+			UserRate:        cfg.RateLimit.UserRate,
+			UserBurst:       cfg.RateLimit.UserBurst,
+			ChatRate:        cfg.RateLimit.ChatRate,
+			ChatBurst:       cfg.RateLimit.ChatBurst,
+			MaxViolations:   cfg.RateLimit.MaxViolations,
+			ViolationWindow: cfg.RateLimit.ViolationWindow,
+		},
+	}
+	if err := wsOptions.Validate(); err != nil {
+		logger.Fatal("websocket options: ", err)
+	}
 
-	hub := ws.NewWsServer(chatRepo, userRepo, redis)
-	go hub.Run()
+	hub := ws.NewWsServer(
+		chatRepo, userRepo, chatService, messageService, presenceService, mentionService,
+		msgBroker, brokerCodec, wsOptions)
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	defer stopHub()
+	go hub.Run(hubCtx)
 	logger.Debug("websocket server started")
 
 	tokenManager, _ := manager.NewManager(cfg.Auth.SigningKey)
@@ -79,7 +120,12 @@ func main() {
 	userSerice := services.NewUserService(userRepo)
 	userHandler := handlers.NewUserhandler(userSerice, tokenManager, cfg)
 
-	router := handlers.Routes(userHandler, hub)
+	chatHandler := handlers.NewChatHandler(chatService, messageService, hub)
+	presenceHandler := handlers.NewPresenceHandler(presenceService)
+	mentionHandler := handlers.NewMentionHandler(mentionService)
+	inviteHandler := handlers.NewInviteHandler(inviteService, hub)
+
+	router := handlers.Routes(userHandler, chatHandler, presenceHandler, mentionHandler, inviteHandler, hub)
 
 	// router.GET("/ws", middleware.AuthUser(tokenManager), func(c *gin.Context) {
 	// 	ws.ServeWS(hub, c)
@@ -101,6 +147,13 @@ func main() {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
+
+	// The streams of the hub have to end before the server can stop.
+	if err := hub.Shutdown(ctx); err != nil {
+		logger.Println("websocket server shutdown: ", err)
+	}
+	stopHub()
+
 	if err := server.Stop(ctx); err != nil {
 		logger.Fatal("Server Shutdown:", err)
 	}